@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// activeApplication — заявки, которые занимают место в матче.
+var activeApplication = []string{"pending", "approved"}
+
+/* ===================== WAITLIST ===================== */
+
+// matchFullTx — заявок на матч столько, сколько позволяет max_participants.
+func matchFullTx(ctx context.Context, tx pgx.Tx, matchID, maxParticipants int) (bool, error) {
+	if maxParticipants <= 0 {
+		return false, nil
+	}
+
+	var count int
+	q := sq.Select("COUNT(*)").
+		From("applications").
+		Where(sq.Eq{"match_id": matchID, "status": activeApplication}).
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, q).Scan(&count); err != nil {
+		return false, err
+	}
+	return count >= maxParticipants, nil
+}
+
+// promoteWaitlistTx переводит заявки из листа ожидания в pending (в порядке
+// подачи), пока в матче есть свободные места. Только для открытого матча.
+func promoteWaitlistTx(ctx context.Context, tx pgx.Tx, matchID int) error {
+	var status string
+	var maxParticipants int
+	qM := sq.Select("status", "max_participants").
+		From("matches").
+		Where(sq.Eq{"id": matchID}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, qM).Scan(&status, &maxParticipants); err != nil {
+		return err
+	}
+	if status != "open" {
+		return nil
+	}
+
+	for {
+		full, err := matchFullTx(ctx, tx, matchID, maxParticipants)
+		if err != nil || full {
+			return err
+		}
+
+		first := sq.Select("id").
+			From("applications").
+			Where(sq.Eq{"match_id": matchID, "status": "waitlisted"}).
+			OrderBy("created_at ASC", "id ASC").
+			Limit(1)
+
+		upd := sq.Update("applications").
+			Set("status", "pending").
+			Where(sq.Expr("id = (?)", first)).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExecTx(ctx, tx, upd)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+	}
+}
+
+// releaseApplicationTx снимает заявку (отказ администратора, отзыв):
+// меняет статус, убирает заявленных из участников матча и освобождает
+// место для листа ожидания.
+func releaseApplicationTx(ctx context.Context, tx pgx.Tx, appID, matchID, userID int, teamID *int, status string) error {
+	upd := sq.Update("applications").
+		Set("status", status).
+		Where(sq.Eq{"id": appID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, upd); err != nil {
+		return err
+	}
+
+	del := sq.Delete("match_participants").
+		Where(sq.Eq{"match_id": matchID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if teamID != nil {
+		del = del.Where(sq.Eq{"team_id": *teamID})
+	} else {
+		del = del.Where(sq.Eq{"user_id": userID, "team_id": nil})
+	}
+	if _, err := qExecTx(ctx, tx, del); err != nil {
+		return err
+	}
+
+	return promoteWaitlistTx(ctx, tx, matchID)
+}
+
+/* ===================== WITHDRAW ===================== */
+
+// POST /api/matches/:id/withdraw
+// Отзыв своей заявки (pending, approved или из листа ожидания) до начала
+// матча. Заявку команды отзывает её владелец или капитан — за всю команду.
+func WithdrawApplication(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var status, title string
+		qM := sq.Select("status", "title").
+			From("matches").
+			Where(sq.Eq{"id": matchID}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qM).Scan(&status, &title); err != nil {
+			jsonErr(c, 404, "Матч не найден")
+			return
+		}
+		if status != "open" {
+			jsonErr(c, 400, "Отозвать заявку можно только до начала матча")
+			return
+		}
+
+		// своя заявка или заявка команды, в которой состоит игрок
+		myTeam := sq.Select("team_id").
+			From("team_members").
+			Where(sq.Eq{"user_id": userID})
+
+		qA := sq.Select("id", "user_id", "team_id").
+			From("applications").
+			Where(sq.Eq{"match_id": matchID, "status": []string{"pending", "approved", "waitlisted"}}).
+			Where(sq.Or{sq.Eq{"user_id": userID}, sq.Expr("team_id IN (?)", myTeam)}).
+			OrderBy("id").
+			Limit(1).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		var appID, applicant int
+		var teamID *int
+		if err := qRowTx(ctx, tx, qA).Scan(&appID, &applicant, &teamID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				jsonErr(c, 404, "Заявка не найдена")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if teamID != nil {
+			ok, err := teamManagerTx(ctx, tx, *teamID, userID)
+			if err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			if !ok {
+				jsonErr(c, 403, "Заявку команды отзывает её создатель или капитан")
+				return
+			}
+		}
+
+		if err := releaseApplicationTx(ctx, tx, appID, matchID, applicant, teamID, "withdrawn"); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &userID, "withdraw_application", "Пользователь отозвал заявку на матч: "+clampRunes(title, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
@@ -3,12 +3,11 @@ package internal
 import (
 	"context"
 	"net/http"
-	"os"
-	"time"
+	"strconv"
 
+	sq "github.com/Masterminds/squirrel"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -31,7 +30,7 @@ func Register(db *pgxpool.Pool) gin.HandlerFunc {
 			c.JSON(400, gin.H{"error": "passwords do not match"})
 			return
 		}
-		if len(req.Password) < 6 {
+		if len(req.Password) < MinPassword {
 			c.JSON(400, gin.H{"error": "password too short"})
 			return
 		}
@@ -63,43 +62,81 @@ func Login(db *pgxpool.Pool, secret string) gin.HandlerFunc {
 			return
 		}
 
+		ctx := context.Background()
+		ip := c.ClientIP()
+
+		// подбор пароля: пауза растёт с каждой неудачей по логину и по IP
+		wait, err := loginRetryAfter(ctx, db, req.Username, ip)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		if wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(wait))
+			c.JSON(429, gin.H{"error": "too many attempts", "retry_after": wait})
+			return
+		}
+
 		var u User
 		var passHash string
-		err := db.QueryRow(context.Background(),
-			"SELECT id, username, role, points, pass_hash FROM users WHERE username=$1",
+		var totp bool
+		err = db.QueryRow(ctx,
+			"SELECT id, username, role, points, pass_hash, banned_at IS NOT NULL, totp_enabled FROM users WHERE username=$1",
 			req.Username,
-		).Scan(&u.ID, &u.Username, &u.Role, &u.Points, &passHash)
+		).Scan(&u.ID, &u.Username, &u.Role, &u.Points, &passHash, &u.Banned, &totp)
 		if err != nil {
+			_ = recordLoginFailure(ctx, db, nil, req.Username, ip)
 			c.JSON(401, gin.H{"error": "invalid credentials"})
 			return
 		}
 		if bcrypt.CompareHashAndPassword([]byte(passHash), []byte(req.Password)) != nil {
+			_ = recordLoginFailure(ctx, db, &u.ID, req.Username, ip)
 			c.JSON(401, gin.H{"error": "invalid credentials"})
 			return
 		}
+		if u.Banned {
+			c.JSON(403, gin.H{"error": "user banned"})
+			return
+		}
 
-		tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
-			UserID: u.ID,
-			Role:   u.Role,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-				Issuer:    "ctf-platform",
-			},
-		})
-		s, _ := tok.SignedString([]byte(secret))
+		// с 2FA cookie выдаётся только после второго шага (/auth/login/2fa)
+		if totp {
+			challenge, err := startMFAChallenge(ctx, db, u.ID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "server error"})
+				return
+			}
+			c.JSON(200, gin.H{"ok": true, "mfa_required": true, "challenge": challenge})
+			return
+		}
 
-		secure := os.Getenv("COOKIE_SECURE") == "1"
-		c.SetCookie(cookieName, s, 86400, "/", "", secure, true)
+		if err := startSession(c, db, secret, u.ID, false); err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		clearLoginFailures(ctx, db, req.Username)
 
 		logAction(db, &u.ID, "login", "success")
 		c.JSON(200, gin.H{"ok": true})
 	}
 }
 
-func Logout() gin.HandlerFunc {
+// Logout отзывает текущую сессию по refresh-токену и очищает cookie.
+func Logout(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.SetCookie(cookieName, "", -1, "/", "", false, true)
+		if refresh, err := c.Cookie(refreshCookieName); err == nil && refresh != "" {
+			upd := sq.Update("sessions").
+				Set("revoked_at", sq.Expr(sqlNowUTC)).
+				Where(sq.Eq{"refresh_hash": hashToken(refresh), "revoked_at": nil}).
+				Suffix("RETURNING id").
+				PlaceholderFormat(sq.Dollar)
+
+			var sid int64
+			if qRow(context.Background(), db, upd).Scan(&sid) == nil {
+				invalidateSession(sid)
+			}
+		}
+		clearAuthCookies(c)
 		c.JSON(http.StatusOK, gin.H{"ok": true})
 	}
 }
@@ -0,0 +1,647 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/* ===================== ENTRANTS ===================== */
+
+// entrant — участник зачёта: команда (TeamID > 0) или отдельный пользователь.
+type entrant struct {
+	UserID int
+	TeamID int
+}
+
+func entrantOf(userID int, teamID *int) entrant {
+	if teamID != nil {
+		return entrant{TeamID: *teamID}
+	}
+	return entrant{UserID: userID}
+}
+
+func (e entrant) userPtr() *int {
+	if e.TeamID > 0 || e.UserID <= 0 {
+		return nil
+	}
+	id := e.UserID
+	return &id
+}
+
+func (e entrant) teamPtr() *int {
+	if e.TeamID <= 0 {
+		return nil
+	}
+	id := e.TeamID
+	return &id
+}
+
+/* ===================== SCOREBOARD ===================== */
+
+// solveCountSQL — число решений задания ch среди текущих участников матча.
+const solveCountSQL = "(SELECT COUNT(*) FROM solves s2 " +
+	"JOIN match_participants mp2 ON mp2.match_id = s2.match_id AND mp2.user_id = s2.user_id " +
+	"WHERE s2.challenge_id = ch.id)"
+
+// challengeValue — стоимость задания после solves решений (формула CTFd).
+// Первый решивший получает полную стоимость, дальше она падает по параболе
+// и через decay решений достигает minimum.
+func challengeValue(scoring string, initial, minimum, decay, solves int) int {
+	if scoring != "dynamic" || decay <= 0 {
+		return initial
+	}
+	if solves > 0 {
+		solves--
+	}
+	v := float64(minimum-initial)/float64(decay*decay)*float64(solves*solves) + float64(initial)
+	value := int(math.Ceil(v))
+	if value < minimum {
+		value = minimum
+	}
+	return value
+}
+
+// loadScoreboardTx считает таблицу матча по решённым заданиям.
+// Стоимость динамических заданий пересчитывается на момент запроса, поэтому
+// ранее решившие тоже получают текущую (уменьшенную) стоимость.
+// Порядок: очки по убыванию, при равенстве — кто раньше сделал последнее решение.
+// Участники без решений тоже попадают в таблицу (в конец).
+func loadScoreboardTx(ctx context.Context, tx pgx.Tx, matchID int) ([]ScoreEntry, error) {
+	type chInfo struct {
+		scoring                 string
+		initial, minimum, decay int
+	}
+
+	qCh := sq.Select("id", "scoring", "points", "minimum", "decay").
+		From("challenges").
+		Where(sq.Eq{"match_id": matchID}).
+		PlaceholderFormat(sq.Dollar)
+
+	rowsC, err := qQueryTx(ctx, tx, qCh)
+	if err != nil {
+		return nil, err
+	}
+	challenges := map[int]chInfo{}
+	for rowsC.Next() {
+		var id int
+		var ci chInfo
+		if err := rowsC.Scan(&id, &ci.scoring, &ci.initial, &ci.minimum, &ci.decay); err != nil {
+			rowsC.Close()
+			return nil, err
+		}
+		challenges[id] = ci
+	}
+	rowsC.Close()
+
+	qPart := sq.Select("mp.user_id", "mp.team_id", "COALESCE(t.name, u.username)").
+		From("match_participants mp").
+		Join("users u ON u.id = mp.user_id").
+		LeftJoin("teams t ON t.id = mp.team_id").
+		Where(sq.Eq{"mp.match_id": matchID}).
+		PlaceholderFormat(sq.Dollar)
+
+	rowsP, err := qQueryTx(ctx, tx, qPart)
+	if err != nil {
+		return nil, err
+	}
+	byEntrant := map[entrant]*ScoreEntry{}
+	for rowsP.Next() {
+		var userID int
+		var teamID *int
+		var name string
+		if err := rowsP.Scan(&userID, &teamID, &name); err != nil {
+			rowsP.Close()
+			return nil, err
+		}
+		e := entrantOf(userID, teamID)
+		if _, ok := byEntrant[e]; !ok {
+			byEntrant[e] = &ScoreEntry{UserID: e.userPtr(), TeamID: e.teamPtr(), Name: name}
+		}
+	}
+	rowsP.Close()
+
+	type solve struct {
+		chID int
+		e    entrant
+		at   time.Time
+	}
+
+	// учитываем только решения тех, кто до сих пор в match_participants
+	qSolves := sq.Select("s.challenge_id", "s.user_id", "s.team_id", "s.created_at").
+		From("solves s").
+		Join("match_participants mp ON mp.match_id = s.match_id AND mp.user_id = s.user_id").
+		Where(sq.Eq{"s.match_id": matchID}).
+		OrderBy("s.created_at ASC", "s.id ASC").
+		PlaceholderFormat(sq.Dollar)
+
+	rowsS, err := qQueryTx(ctx, tx, qSolves)
+	if err != nil {
+		return nil, err
+	}
+	solves := []solve{}
+	counts := map[int]int{}
+	for rowsS.Next() {
+		var sv solve
+		var userID int
+		var teamID *int
+		if err := rowsS.Scan(&sv.chID, &userID, &teamID, &sv.at); err != nil {
+			rowsS.Close()
+			return nil, err
+		}
+		sv.e = entrantOf(userID, teamID)
+		solves = append(solves, sv)
+		counts[sv.chID]++
+	}
+	rowsS.Close()
+
+	for _, sv := range solves {
+		se, ok := byEntrant[sv.e]
+		if !ok {
+			continue
+		}
+		ci := challenges[sv.chID]
+		se.Score += challengeValue(ci.scoring, ci.initial, ci.minimum, ci.decay, counts[sv.chID])
+		se.Solves++
+		t := sv.at
+		se.LastSolve = &t
+	}
+
+	out := make([]ScoreEntry, 0, len(byEntrant))
+	for _, se := range byEntrant {
+		out = append(out, *se)
+	}
+	sortScoreboard(out)
+	return out, nil
+}
+
+func (se ScoreEntry) entrant() entrant {
+	if se.TeamID != nil {
+		return entrant{TeamID: *se.TeamID}
+	}
+	if se.UserID != nil {
+		return entrant{UserID: *se.UserID}
+	}
+	return entrant{}
+}
+
+// entrantMembersTx — пользователи, выступающие в матче за участника зачёта.
+func entrantMembersTx(ctx context.Context, tx pgx.Tx, matchID int, e entrant) ([]int, error) {
+	q := sq.Select("user_id").
+		From("match_participants").
+		Where(sq.Eq{"match_id": matchID}).
+		PlaceholderFormat(sq.Dollar)
+	if e.TeamID > 0 {
+		q = q.Where(sq.Eq{"team_id": e.TeamID})
+	} else {
+		q = q.Where(sq.Eq{"user_id": e.UserID})
+	}
+
+	rows, err := qQueryTx(ctx, tx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func sortScoreboard(entries []ScoreEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if (a.LastSolve == nil) != (b.LastSolve == nil) {
+			return a.LastSolve != nil
+		}
+		if a.LastSolve != nil && !a.LastSolve.Equal(*b.LastSolve) {
+			return a.LastSolve.Before(*b.LastSolve)
+		}
+		return a.Name < b.Name
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+}
+
+// GET /api/matches/:id/scoreboard
+func MatchScoreboard(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		// только чтение; транзакция нужна loadScoreboardTx
+		ctx := context.Background()
+		tx, err := db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var found int
+		qM := sq.Select("1").From("matches").Where(sq.Eq{"id": matchID}).PlaceholderFormat(sq.Dollar)
+		if err := qRowTx(ctx, tx, qM).Scan(&found); err != nil {
+			jsonErr(c, 404, "Матч не найден")
+			return
+		}
+
+		board, err := loadScoreboardTx(ctx, tx, matchID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, board)
+	}
+}
+
+/* ===================== CHALLENGES (USER) ===================== */
+
+// participantTeam возвращает team_id участника матча (nil для solo).
+// pgx.ErrNoRows — пользователь не участвует в матче.
+func participantTeam(ctx context.Context, db *pgxpool.Pool, matchID, userID int) (*int, error) {
+	q := sq.Select("team_id").
+		From("match_participants").
+		Where(sq.Eq{"match_id": matchID, "user_id": userID}).
+		PlaceholderFormat(sq.Dollar)
+
+	var teamID *int
+	err := qRow(ctx, db, q).Scan(&teamID)
+	return teamID, err
+}
+
+// GET /api/matches/:id/challenges (только для участников матча)
+func ListChallenges(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		ctx := context.Background()
+
+		teamID, err := participantTeam(ctx, db, matchID, userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			jsonErr(c, 403, "Вы не участвуете в матче")
+			return
+		}
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		mine := sq.Select("1").
+			From("solves s").
+			Where(sq.Expr("s.challenge_id = ch.id")).
+			PlaceholderFormat(sq.Dollar)
+		if teamID != nil {
+			mine = mine.Where(sq.Eq{"s.team_id": *teamID})
+		} else {
+			mine = mine.Where(sq.Eq{"s.user_id": userID}).Where(sq.Expr("s.team_id IS NULL"))
+		}
+
+		q := sq.Select("ch.id", "ch.match_id", "ch.name", "ch.category", "ch.description",
+			"ch.points", "ch.scoring", "ch.minimum", "ch.decay", solveCountSQL).
+			Column(sq.Expr("EXISTS(?)", mine)).
+			From("challenges ch").
+			Where(sq.Eq{"ch.match_id": matchID}).
+			OrderBy("ch.category ASC", "ch.points ASC", "ch.id ASC").
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []Challenge{}
+		for rows.Next() {
+			var ch Challenge
+			if err := rows.Scan(&ch.ID, &ch.MatchID, &ch.Name, &ch.Category, &ch.Description,
+				&ch.Points, &ch.Scoring, &ch.Minimum, &ch.Decay, &ch.Solves, &ch.Solved); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			ch.Value = challengeValue(ch.Scoring, ch.Points, ch.Minimum, ch.Decay, ch.Solves)
+			out = append(out, ch)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// POST /api/matches/:id/challenges/:cid/submit  { "flag": "CTF{...}" }
+func SubmitFlag(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		chID, _ := strconv.Atoi(c.Param("cid"))
+		if matchID <= 0 || chID <= 0 {
+			jsonErr(c, 400, "Некорректное задание")
+			return
+		}
+
+		var req struct {
+			Flag string `json:"flag"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		req.Flag = clampRunes(req.Flag, MaxFlag)
+		if req.Flag == "" {
+			jsonErr(c, 400, "Введите флаг")
+			return
+		}
+
+		ctx := context.Background()
+
+		var status string
+		qM := sq.Select("status").From("matches").Where(sq.Eq{"id": matchID}).PlaceholderFormat(sq.Dollar)
+		if err := qRow(ctx, db, qM).Scan(&status); err != nil {
+			jsonErr(c, 404, "Матч не найден")
+			return
+		}
+		if status != "closed" {
+			jsonErr(c, 400, matchStatusErr(status))
+			return
+		}
+
+		teamID, err := participantTeam(ctx, db, matchID, userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			jsonErr(c, 403, "Вы не участвуете в матче")
+			return
+		}
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		var flag, name string
+		qCh := sq.Select("flag", "name").
+			From("challenges").
+			Where(sq.Eq{"id": chID, "match_id": matchID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRow(ctx, db, qCh).Scan(&flag, &name); err != nil {
+			jsonErr(c, 404, "Задание не найдено")
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(req.Flag), []byte(flag)) != 1 {
+			c.JSON(200, gin.H{"ok": true, "correct": false})
+			return
+		}
+
+		ins := sq.Insert("solves").
+			Columns("challenge_id", "match_id", "user_id", "team_id").
+			Values(chID, matchID, userID, teamID).
+			Suffix("ON CONFLICT DO NOTHING").
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExec(ctx, db, ins)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			c.JSON(200, gin.H{"ok": true, "correct": true, "already_solved": true})
+			return
+		}
+
+		logAction(db, &userID, "solve_challenge", "Решено задание: "+clampRunes(name, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true, "correct": true})
+	}
+}
+
+/* ===================== ADMIN: CHALLENGES CRUD ===================== */
+
+type challengeReq struct {
+	Name        string `json:"name"`
+	Category    string `json:"category"`
+	Description string `json:"description"`
+	Points      int    `json:"points"`
+	Scoring     string `json:"scoring"`
+	Minimum     int    `json:"minimum"`
+	Decay       int    `json:"decay"`
+	Flag        string `json:"flag"`
+}
+
+func (r *challengeReq) normalize() bool {
+	r.Name = clampRunes(r.Name, MaxChallengeName)
+	r.Category = clampRunes(strings.ToLower(r.Category), MaxCategory)
+	r.Description = clampRunes(r.Description, MaxChallengeDesc)
+	r.Flag = clampRunes(r.Flag, MaxFlag)
+	if r.Name == "" || r.Flag == "" || r.Points <= 0 || r.Points > MaxChallengePts {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(r.Scoring)) {
+	case "", "static":
+		r.Scoring = "static"
+		r.Minimum = r.Points
+		r.Decay = 0
+		return true
+	case "dynamic":
+		r.Scoring = "dynamic"
+		return r.Minimum >= 0 && r.Minimum <= r.Points && r.Decay > 0 && r.Decay <= MaxChallengeDecay
+	default:
+		return false
+	}
+}
+
+// adminMatchEditable проверяет, что матч существует и ещё не завершён.
+func adminMatchEditable(ctx context.Context, c *gin.Context, db *pgxpool.Pool, matchID int) bool {
+	var st string
+	qSt := sq.Select("status").From("matches").Where(sq.Eq{"id": matchID}).PlaceholderFormat(sq.Dollar)
+	if err := qRow(ctx, db, qSt).Scan(&st); err != nil {
+		jsonErr(c, 404, "Матч не найден")
+		return false
+	}
+	if st == "finished" {
+		jsonErr(c, 400, "Нельзя изменять завершённый матч")
+		return false
+	}
+	return true
+}
+
+// GET /api/admin/matches/:id/challenges
+func AdminListChallenges(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		ctx := context.Background()
+
+		q := sq.Select("ch.id", "ch.match_id", "ch.name", "ch.category", "ch.description",
+			"ch.points", "ch.scoring", "ch.minimum", "ch.decay", "ch.flag", solveCountSQL).
+			From("challenges ch").
+			Where(sq.Eq{"ch.match_id": matchID}).
+			OrderBy("ch.category ASC", "ch.points ASC", "ch.id ASC").
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []Challenge{}
+		for rows.Next() {
+			var ch Challenge
+			if err := rows.Scan(&ch.ID, &ch.MatchID, &ch.Name, &ch.Category, &ch.Description,
+				&ch.Points, &ch.Scoring, &ch.Minimum, &ch.Decay, &ch.Flag, &ch.Solves); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			ch.Value = challengeValue(ch.Scoring, ch.Points, ch.Minimum, ch.Decay, ch.Solves)
+			out = append(out, ch)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// POST /api/admin/matches/:id/challenges
+func AdminCreateChallenge(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		var req challengeReq
+		if err := c.BindJSON(&req); err != nil || !req.normalize() {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		ctx := context.Background()
+		if !adminMatchEditable(ctx, c, db, matchID) {
+			return
+		}
+
+		ins := sq.Insert("challenges").
+			Columns("match_id", "name", "category", "description", "points", "scoring", "minimum", "decay", "flag").
+			Values(matchID, req.Name, req.Category, req.Description, req.Points, req.Scoring, req.Minimum, req.Decay, req.Flag).
+			Suffix("RETURNING id").
+			PlaceholderFormat(sq.Dollar)
+
+		var id int
+		if err := qRow(ctx, db, ins).Scan(&id); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_create_challenge", "Администратор добавил задание: "+clampRunes(req.Name, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true, "challenge_id": id})
+	}
+}
+
+// PUT /api/admin/matches/:id/challenges/:cid
+func AdminUpdateChallenge(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		chID, _ := strconv.Atoi(c.Param("cid"))
+		if matchID <= 0 || chID <= 0 {
+			jsonErr(c, 400, "Некорректное задание")
+			return
+		}
+
+		var req challengeReq
+		if err := c.BindJSON(&req); err != nil || !req.normalize() {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		ctx := context.Background()
+		if !adminMatchEditable(ctx, c, db, matchID) {
+			return
+		}
+
+		upd := sq.Update("challenges").
+			Set("name", req.Name).
+			Set("category", req.Category).
+			Set("description", req.Description).
+			Set("points", req.Points).
+			Set("scoring", req.Scoring).
+			Set("minimum", req.Minimum).
+			Set("decay", req.Decay).
+			Set("flag", req.Flag).
+			Where(sq.Eq{"id": chID, "match_id": matchID}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExec(ctx, db, upd)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 404, "Задание не найдено")
+			return
+		}
+
+		logAction(db, &actor, "admin_update_challenge", "Администратор изменил задание")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// DELETE /api/admin/matches/:id/challenges/:cid
+func AdminDeleteChallenge(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		chID, _ := strconv.Atoi(c.Param("cid"))
+		if matchID <= 0 || chID <= 0 {
+			jsonErr(c, 400, "Некорректное задание")
+			return
+		}
+
+		ctx := context.Background()
+		if !adminMatchEditable(ctx, c, db, matchID) {
+			return
+		}
+
+		del := sq.Delete("challenges").
+			Where(sq.Eq{"id": chID, "match_id": matchID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExec(ctx, db, del); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_delete_challenge", "Администратор удалил задание")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
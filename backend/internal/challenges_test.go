@@ -0,0 +1,46 @@
+package internal
+
+import "testing"
+
+func TestChallengeValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		scoring string
+		initial int
+		minimum int
+		decay   int
+		solves  int
+		want    int
+	}{
+		{"static ignores solves", "static", 500, 100, 10, 50, 500},
+		{"dynamic without decay", "dynamic", 500, 100, 0, 50, 500},
+		{"no solves", "dynamic", 500, 100, 10, 0, 500},
+		{"first solver gets full value", "dynamic", 500, 100, 10, 1, 500},
+		{"second solver", "dynamic", 500, 100, 10, 2, 496},
+		{"halfway", "dynamic", 500, 100, 10, 6, 400},
+		{"reaches minimum after decay solves", "dynamic", 500, 100, 10, 11, 100},
+		{"never below minimum", "dynamic", 500, 100, 10, 50, 100},
+		{"rounds up", "dynamic", 100, 0, 3, 3, 56},
+	}
+	for _, tt := range tests {
+		got := challengeValue(tt.scoring, tt.initial, tt.minimum, tt.decay, tt.solves)
+		if got != tt.want {
+			t.Errorf("%s: challengeValue(%q, %d, %d, %d, %d) = %d, want %d",
+				tt.name, tt.scoring, tt.initial, tt.minimum, tt.decay, tt.solves, got, tt.want)
+		}
+	}
+}
+
+func TestChallengeValueNonIncreasing(t *testing.T) {
+	prev := challengeValue("dynamic", 1000, 50, 30, 0)
+	for solves := 1; solves <= 100; solves++ {
+		v := challengeValue("dynamic", 1000, 50, 30, solves)
+		if v > prev {
+			t.Fatalf("solves=%d: value %d grew from %d", solves, v, prev)
+		}
+		if v < 50 {
+			t.Fatalf("solves=%d: value %d below minimum", solves, v)
+		}
+		prev = v
+	}
+}
@@ -8,4 +8,49 @@ const (
 	MaxLogDetails   = 50
 	MaxReportLine   = 30
 	MaxTeamMembers  = 5
-)
\ No newline at end of file
+
+	TeamInviteTTLHours = 72 // срок действия приглашения в команду
+
+	AccessTokenMinutes = 15 // access-токен в cookie ctf_token
+	RefreshTokenDays   = 30 // сессия без обновления истекает
+	MaxUserAgent       = 200
+	MaxBanReason       = 200
+
+	MinPassword           = 6
+	MaxPassword           = 72 // предел bcrypt
+	PasswordResetTTLHours = 24
+
+	TOTPIssuer          = "CTF Platform"
+	MFAChallengeMinutes = 5 // на ввод кода после пароля
+	MaxMFAAttempts      = 5
+	RecoveryCodeCount   = 10
+
+	LoginBackoffBaseSeconds   = 1  // первая пауза, дальше удваивается
+	LoginLockoutMinutes       = 15 // блокировка после порога неудач
+	LoginAttemptWindowMinutes = 60 // без неудач дольше — счётчик с нуля
+
+	MaxChallengeName  = 30
+	MaxCategory       = 20
+	MaxChallengeDesc  = 2000
+	MaxFlag           = 100
+	MaxChallengePts   = 10_000
+	MaxChallengeDecay = 1_000
+
+	MaxTournamentTitle    = 30
+	MaxTournamentEntrants = 64
+
+	InitialRating = 1500
+	EloK          = 32
+
+	MaxSeasonTitle       = 30
+	MaxMatchParticipants = 1000
+	MaxMinRating         = 10_000
+	DefaultPage          = 100
+	MaxPage              = 500
+)
+
+const MaxPlacements = 20
+
+// DefaultPlacementPoints — очки за 1-е, 2-е, 3-е... место при автоматическом
+// подведении итогов матча, если администратор не передал свою таблицу.
+var DefaultPlacementPoints = []int{100, 50, 25}
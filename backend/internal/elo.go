@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"math"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+/* ===================== ELO ===================== */
+
+// eloDeltas — многопользовательский Elo: каждый участник сравнивается с
+// каждым (победа 1, ничья 0.5), K делится на число соперников.
+// places — занятые места (1 — лучшее, равные места = ничья).
+func eloDeltas(ratings []float64, places []int, k float64) []int {
+	n := len(ratings)
+	out := make([]int, n)
+	if n < 2 {
+		return out
+	}
+	for i := 0; i < n; i++ {
+		var expected, actual float64
+		for j := 0; j < n; j++ {
+			if i == j {
+				continue
+			}
+			expected += 1 / (1 + math.Pow(10, (ratings[j]-ratings[i])/400))
+			switch {
+			case places[i] < places[j]:
+				actual++
+			case places[i] == places[j]:
+				actual += 0.5
+			}
+		}
+		out[i] = int(math.Round(k / float64(n-1) * (actual - expected)))
+	}
+	return out
+}
+
+// matchPlacesTx — места участников по итогам матча. Победитель всегда первый
+// (в т.ч. при ручном выборе), остальные — по таблице решений; без решений
+// все остальные делят второе место.
+func matchPlacesTx(ctx context.Context, tx pgx.Tx, matchID int, winner entrant) ([]entrant, []int, error) {
+	board, err := loadScoreboardTx(ctx, tx, matchID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entrants := []entrant{}
+	places := []int{}
+	if winner != (entrant{}) {
+		entrants = append(entrants, winner)
+		places = append(places, 1)
+	}
+
+	place := len(entrants)
+	lastScore := -1
+	for _, se := range board {
+		e := se.entrant()
+		if e == winner {
+			continue
+		}
+		// участники с равными очками без решений делят место
+		if se.Score == 0 && lastScore == 0 {
+			places = append(places, place)
+		} else {
+			place = len(entrants) + 1
+			places = append(places, place)
+		}
+		lastScore = se.Score
+		entrants = append(entrants, e)
+	}
+	return entrants, places, nil
+}
+
+// applyRatingTx пересчитывает Elo всех участников завершённого матча.
+// Рейтинг команды — средний рейтинг её состава, изменение получает каждый игрок.
+func applyRatingTx(ctx context.Context, tx pgx.Tx, matchID int, winner entrant) error {
+	entrants, places, err := matchPlacesTx(ctx, tx, matchID, winner)
+	if err != nil {
+		return err
+	}
+	// состав берём из участников матча; выбывшие из матча в расчёт не идут
+	var members [][]int
+	var ratings []float64
+	var kept []int
+	for i, e := range entrants {
+		ids, err := entrantMembersTx(ctx, tx, matchID, e)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		var avg float64
+		q := sq.Select("AVG(rating)").
+			From("users").
+			Where(sq.Eq{"id": ids}).
+			PlaceholderFormat(sq.Dollar)
+		if err := qRowTx(ctx, tx, q).Scan(&avg); err != nil {
+			return err
+		}
+		members = append(members, ids)
+		ratings = append(ratings, avg)
+		kept = append(kept, places[i])
+	}
+	if len(kept) < 2 || kept[0] == kept[len(kept)-1] {
+		return nil // некого сравнивать или все разделили одно место
+	}
+
+	deltas := eloDeltas(ratings, kept, EloK)
+	for i, ids := range members {
+		if deltas[i] == 0 {
+			continue
+		}
+
+		// фиксируем старое и новое значение — нужно для отмены результата
+		ins := sq.Insert("rating_changes").
+			Columns("user_id", "match_id", "old_rating", "new_rating").
+			Select(sq.Select("id").
+				Column(sq.Expr("?::int", matchID)).
+				Column("rating").
+				Column(sq.Expr("rating + ?::int", deltas[i])).
+				From("users").
+				Where(sq.Eq{"id": ids})).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, ins); err != nil {
+			return err
+		}
+
+		upd := sq.Update("users").
+			Set("rating", sq.Expr("rating + ?", deltas[i])).
+			Where(sq.Eq{"id": ids}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
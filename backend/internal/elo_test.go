@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEloDeltas(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratings []float64
+		places  []int
+		want    []int
+	}{
+		{"single entrant", []float64{1500}, []int{1}, []int{0}},
+		{"equal ratings", []float64{1500, 1500}, []int{1, 2}, []int{16, -16}},
+		{"draw", []float64{1500, 1500}, []int{1, 1}, []int{0, 0}},
+		{"favourite wins", []float64{1600, 1400}, []int{1, 2}, []int{8, -8}},
+		{"upset", []float64{1400, 1600}, []int{1, 2}, []int{24, -24}},
+		{"three players", []float64{1500, 1500, 1500}, []int{1, 2, 3}, []int{16, 0, -16}},
+		{"shared second place", []float64{1500, 1500, 1500}, []int{1, 2, 2}, []int{16, -8, -8}},
+	}
+	for _, tt := range tests {
+		if got := eloDeltas(tt.ratings, tt.places, 32); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: eloDeltas(%v, %v) = %v, want %v", tt.name, tt.ratings, tt.places, got, tt.want)
+		}
+	}
+}
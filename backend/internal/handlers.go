@@ -2,11 +2,14 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"strings"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -28,13 +31,44 @@ func jsonErr(c *gin.Context, code int, msg string) {
 func normStatus(s string) string {
 	s = clampRunes(strings.ToLower(s), MaxStatus)
 	switch s {
-	case "open", "finished", "all", "":
+	case "open", "closed", "finished", "all", "":
 		return s
 	default:
 		return "invalid"
 	}
 }
 
+// Статусы матча: open — принимаются заявки, состав ещё меняется;
+// closed — регистрация закрыта, состав зафиксирован, идёт матч (сдаются флаги);
+// finished — итоги подведены.
+// matchTransitions — допустимые переходы. В finished матч переводят только
+// /winner и /finalize (или планировщик), т.к. при этом начисляются очки.
+var matchTransitions = map[string][]string{
+	"open":   {"closed", "finished"},
+	"closed": {"open", "finished"},
+}
+
+func canMoveMatch(from, to string) bool {
+	for _, s := range matchTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// matchStatusErr — сообщение, почему действие недоступно в текущем статусе.
+func matchStatusErr(status string) string {
+	switch status {
+	case "open":
+		return "Матч ещё не начался"
+	case "closed":
+		return "Регистрация на матч закрыта"
+	default:
+		return "Матч уже завершён"
+	}
+}
+
 func normMode(s string) string {
 	s = clampRunes(strings.ToLower(s), MaxMode)
 	switch s {
@@ -53,12 +87,12 @@ func Me(db *pgxpool.Pool) gin.HandlerFunc {
 		ctx := context.Background()
 
 		var u User
-		q := sq.Select("id", "username", "role", "points").
+		q := sq.Select("id", "username", "role", "points", "rating").
 			From("users").
 			Where(sq.Eq{"id": id}).
 			PlaceholderFormat(sq.Dollar)
 
-		if err := qRow(ctx, db, q).Scan(&u.ID, &u.Username, &u.Role, &u.Points); err != nil {
+		if err := qRow(ctx, db, q).Scan(&u.ID, &u.Username, &u.Role, &u.Points, &u.Rating); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
@@ -85,15 +119,18 @@ func userHasAnyTeam(db *pgxpool.Pool, userID int) (bool, error) {
 
 /* ===================== RATING ===================== */
 
+// GET /api/rating?limit=&offset=
 func Rating(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		limit, offset := pageParams(c)
 		ctx := context.Background()
 
-		q := sq.Select("id", "username", "role", "points").
+		q := sq.Select("id", "username", "role", "points", "rating").
 			From("users").
 			Where(sq.NotEq{"role": "admin"}).
-			OrderBy("points DESC", "id ASC").
-			Limit(100).
+			OrderBy("rating DESC", "points DESC", "id ASC").
+			Limit(limit).
+			Offset(offset).
 			PlaceholderFormat(sq.Dollar)
 
 		rows, err := qQuery(ctx, db, q)
@@ -106,7 +143,7 @@ func Rating(db *pgxpool.Pool) gin.HandlerFunc {
 		var out []User
 		for rows.Next() {
 			var u User
-			_ = rows.Scan(&u.ID, &u.Username, &u.Role, &u.Points)
+			_ = rows.Scan(&u.ID, &u.Username, &u.Role, &u.Points, &u.Rating)
 			out = append(out, u)
 		}
 		c.JSON(200, out)
@@ -115,7 +152,7 @@ func Rating(db *pgxpool.Pool) gin.HandlerFunc {
 
 /* ===================== MATCHES (USER) ===================== */
 
-// GET /api/matches?status=open|finished|all
+// GET /api/matches?status=open|closed|finished|all
 func ListMatches(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		status := normStatus(c.Query("status"))
@@ -126,7 +163,8 @@ func ListMatches(db *pgxpool.Pool) gin.HandlerFunc {
 
 		ctx := context.Background()
 
-		q := sq.Select("id", "title", "mode", "status").
+		q := sq.Select("id", "title", "mode", "status", "starts_at", "ends_at", "tournament_id").
+			Columns(ruleColumns("")...).
 			From("matches").
 			OrderBy("id DESC").
 			Limit(200).
@@ -146,7 +184,7 @@ func ListMatches(db *pgxpool.Pool) gin.HandlerFunc {
 		var out []Match
 		for rows.Next() {
 			var m Match
-			_ = rows.Scan(&m.ID, &m.Title, &m.Mode, &m.Status)
+			_ = rows.Scan(append([]any{&m.ID, &m.Title, &m.Mode, &m.Status, &m.StartsAt, &m.EndsAt, &m.TournamentID}, m.dest()...)...)
 			out = append(out, m)
 		}
 		c.JSON(200, out)
@@ -159,6 +197,11 @@ func MyApplications(db *pgxpool.Pool) gin.HandlerFunc {
 		userID := uid(c)
 		ctx := context.Background()
 
+		if c.Query("detailed") == "1" {
+			myApplicationsDetailed(c, db, userID)
+			return
+		}
+
 		q := sq.Select("match_id", "status").
 			From("applications").
 			Where(sq.Eq{"user_id": userID}).
@@ -182,6 +225,59 @@ func MyApplications(db *pgxpool.Pool) gin.HandlerFunc {
 	}
 }
 
+// myApplicationsDetailed — заявки игрока и его команды (по зафиксированному
+// составу) с местом в листе ожидания.
+func myApplicationsDetailed(c *gin.Context, db *pgxpool.Pool, userID int) {
+	ctx := context.Background()
+
+	inRoster := sq.Select("1").
+		From("application_roster r").
+		Where(sq.Expr("r.application_id = a.id")).
+		Where(sq.Eq{"r.user_id": userID})
+
+	position := sq.Select("COUNT(*) + 1").
+		From("applications w").
+		Where(sq.Expr("w.match_id = a.match_id")).
+		Where(sq.Eq{"w.status": "waitlisted"}).
+		Where(sq.Expr("(w.created_at, w.id) < (a.created_at, a.id)"))
+
+	q := sq.Select("a.id", "a.match_id", "m.title", "a.team_id", "a.status").
+		Column(sq.Expr("CASE WHEN a.status = 'waitlisted' THEN (?) END", position)).
+		From("applications a").
+		Join("matches m ON m.id = a.match_id").
+		Where(sq.Or{sq.Eq{"a.user_id": userID}, sq.Expr("EXISTS(?)", inRoster)}).
+		OrderBy("a.id DESC").
+		Limit(200).
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := qQuery(ctx, db, q)
+	if err != nil {
+		jsonErr(c, 500, "Ошибка сервера")
+		return
+	}
+	defer rows.Close()
+
+	type appRow struct {
+		ID       int    `json:"id"`
+		MatchID  int    `json:"match_id"`
+		Match    string `json:"match"`
+		TeamID   *int   `json:"team_id,omitempty"`
+		Status   string `json:"status"`
+		Position *int   `json:"waitlist_position,omitempty"`
+	}
+
+	out := []appRow{}
+	for rows.Next() {
+		var r appRow
+		if err := rows.Scan(&r.ID, &r.MatchID, &r.Match, &r.TeamID, &r.Status, &r.Position); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		out = append(out, r)
+	}
+	c.JSON(200, out)
+}
+
 // POST /api/matches/:id/apply (для team матчей нужен team_id)
 func ApplyToMatch(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -196,63 +292,168 @@ func ApplyToMatch(db *pgxpool.Pool) gin.HandlerFunc {
 			TeamID *int `json:"team_id"`
 		}
 		_ = c.BindJSON(&req)
+		if req.TeamID != nil && *req.TeamID <= 0 {
+			req.TeamID = nil
+		}
 
 		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
 
+		// матч блокируется: лимит участников проверяется без гонок
 		var mode, status, title string
-		qMatch := sq.Select("mode", "status", "title").
+		var tournamentID *int
+		var rules MatchRules
+		qMatch := sq.Select("mode", "status", "title", "tournament_id").
+			Columns(ruleColumns("")...).
 			From("matches").
 			Where(sq.Eq{"id": matchID}).
+			Suffix("FOR UPDATE").
 			PlaceholderFormat(sq.Dollar)
 
-		if err := qRow(ctx, db, qMatch).Scan(&mode, &status, &title); err != nil {
+		if err := qRowTx(ctx, tx, qMatch).Scan(append([]any{&mode, &status, &title, &tournamentID}, rules.dest()...)...); err != nil {
 			jsonErr(c, 404, "Матч не найден")
 			return
 		}
+		if tournamentID != nil {
+			jsonErr(c, 400, "Участники матча определяются турнирной сеткой")
+			return
+		}
 
 		if status != "open" {
-			jsonErr(c, 400, "Нельзя подать заявку на завершённый матч")
+			jsonErr(c, 400, matchStatusErr(status))
 			return
 		}
 
-		if mode == "team" {
-			if req.TeamID == nil || *req.TeamID <= 0 {
+		if mode != "team" {
+			req.TeamID = nil
+		} else if req.TeamID == nil {
+			if !rules.AllowSolo {
 				jsonErr(c, 400, "Выберите команду")
 				return
 			}
+		} else {
+			ok, err := teamManagerTx(ctx, tx, *req.TeamID, userID)
+			if err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			if !ok {
+				jsonErr(c, 403, "Заявку от команды подаёт её создатель или капитан")
+				return
+			}
 
-			sub := sq.Select("1").
-				From("team_members").
-				Where(sq.Eq{"team_id": *req.TeamID, "user_id": userID}).
+			// состав фиксируется в момент подачи: команда блокируется,
+			// чтобы он не поменялся между проверкой и записью
+			lock := sq.Select("id").
+				From("teams").
+				Where(sq.Eq{"id": *req.TeamID, "disbanded_at": nil}).
+				Suffix("FOR UPDATE").
 				PlaceholderFormat(sq.Dollar)
 
-			qExists := sq.Select().
-				Column(sq.Expr("EXISTS(?)", sub)).
-				PlaceholderFormat(sq.Dollar)
+			var lockedID int
+			if err := qRowTx(ctx, tx, lock).Scan(&lockedID); err != nil {
+				jsonErr(c, 404, "Команда не найдена")
+				return
+			}
+		}
 
-			var ok bool
-			_ = qRow(ctx, db, qExists).Scan(&ok)
-			if !ok {
-				jsonErr(c, 403, "Вы не состоите в выбранной команде")
+		if mode == "team" && req.TeamID == nil {
+			// одиночка в командном матче не должен состоять в команде
+			has, err := userHasAnyTeam(db, userID)
+			if err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			if has {
+				jsonErr(c, 400, "Вы состоите в команде — подайте заявку от неё")
 				return
 			}
+		}
+
+		reason, err := checkEligibilityTx(ctx, tx, matchID, rules, userID, req.TeamID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if reason != "" {
+			jsonErr(c, 400, reason)
+			return
+		}
+
+		appStatus := "pending"
+		full, err := matchFullTx(ctx, tx, matchID, rules.MaxParticipants)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if full {
+			appStatus = "waitlisted"
+		}
+
+		// отозванная ранее заявка не мешает подать новую
+		delW := sq.Delete("applications").
+			Where(sq.Eq{"match_id": matchID, "status": "withdrawn"}).
+			PlaceholderFormat(sq.Dollar)
+		if req.TeamID != nil {
+			delW = delW.Where(sq.Or{sq.Eq{"user_id": userID}, sq.Eq{"team_id": *req.TeamID}})
 		} else {
-			req.TeamID = nil
+			delW = delW.Where(sq.Eq{"user_id": userID})
+		}
+		if _, err := qExecTx(ctx, tx, delW); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
 		}
 
 		ins := sq.Insert("applications").
-			Columns("match_id", "user_id", "team_id").
-			Values(matchID, userID, req.TeamID).
-			Suffix("ON CONFLICT(match_id,user_id) DO NOTHING").
+			Columns("match_id", "user_id", "team_id", "status").
+			Values(matchID, userID, req.TeamID, appStatus).
+			Suffix("ON CONFLICT DO NOTHING RETURNING id").
 			PlaceholderFormat(sq.Dollar)
 
-		if _, err := qExec(ctx, db, ins); err != nil {
+		var appID int
+		if err := qRowTx(ctx, tx, ins).Scan(&appID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				jsonErr(c, 400, "Заявка на этот матч уже подана")
+				return
+			}
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
 
-		logAction(db, &userID, "apply_match", "Пользователь подал заявку на матч: "+clampRunes(title, MaxReportLine))
-		c.JSON(200, gin.H{"ok": true})
+		if req.TeamID != nil {
+			roster := sq.Select().
+				Column(sq.Expr("?::int", appID)).
+				Column("user_id").
+				From("team_members").
+				Where(sq.Eq{"team_id": *req.TeamID})
+
+			insR := sq.Insert("application_roster").
+				Columns("application_id", "user_id").
+				Select(roster).
+				PlaceholderFormat(sq.Dollar)
+
+			if _, err := qExecTx(ctx, tx, insR); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if full {
+			logAction(db, &userID, "waitlist_match", "Пользователь в листе ожидания матча: "+clampRunes(title, MaxReportLine))
+		} else {
+			logAction(db, &userID, "apply_match", "Пользователь подал заявку на матч: "+clampRunes(title, MaxReportLine))
+		}
+		c.JSON(200, gin.H{"ok": true, "status": appStatus})
 	}
 }
 
@@ -262,7 +463,8 @@ func MyHistory(db *pgxpool.Pool) gin.HandlerFunc {
 		userID := uid(c)
 		ctx := context.Background()
 
-		q := sq.Select("m.id", "m.title", "m.mode", "m.status").
+		q := sq.Select("m.id", "m.title", "m.mode", "m.status", "m.starts_at", "m.ends_at", "m.tournament_id").
+			Columns(ruleColumns("m.")...).
 			From("match_participants mp").
 			Join("matches m ON m.id = mp.match_id").
 			Where(sq.Eq{"mp.user_id": userID}).
@@ -279,7 +481,7 @@ func MyHistory(db *pgxpool.Pool) gin.HandlerFunc {
 		var out []Match
 		for rows.Next() {
 			var m Match
-			_ = rows.Scan(&m.ID, &m.Title, &m.Mode, &m.Status)
+			_ = rows.Scan(append([]any{&m.ID, &m.Title, &m.Mode, &m.Status, &m.StartsAt, &m.EndsAt, &m.TournamentID}, m.dest()...)...)
 			out = append(out, m)
 		}
 		c.JSON(200, out)
@@ -360,7 +562,7 @@ func ListOpenTeams(db *pgxpool.Pool) gin.HandlerFunc {
 
 		qTeams := sq.Select("id", "name", "is_open").
 			From("teams").
-			Where(sq.Eq{"is_open": true}).
+			Where(sq.Eq{"is_open": true, "disbanded_at": nil}).
 			OrderBy("id DESC").
 			PlaceholderFormat(sq.Dollar)
 
@@ -428,11 +630,18 @@ func MyTeams(db *pgxpool.Pool) gin.HandlerFunc {
 			ID      int        `json:"id"`
 			Name    string     `json:"name"`
 			IsOpen  bool       `json:"is_open"`
-			OwnerID int        `json:"owner_id"` // ✅ нужно фронту, чтобы понять owner
-			Members []UserMini `json:"members"`
+			OwnerID   int        `json:"owner_id"` // ✅ нужно фронту, чтобы понять owner
+			CaptainID *int       `json:"captain_id"`
+			Members   []UserMini `json:"members"`
 		}
 
+		qCaptain := sq.Select("c.user_id").
+			From("team_members c").
+			Where(sq.Expr("c.team_id = t.id AND c.is_captain")).
+			Limit(1)
+
 		qTeams := sq.Select("t.id", "t.name", "t.is_open", "t.owner_id").
+			Column(sq.Expr("(?)", qCaptain)).
 			From("team_members tm").
 			Join("teams t ON t.id = tm.team_id").
 			Where(sq.Eq{"tm.user_id": userID}).
@@ -451,7 +660,7 @@ func MyTeams(db *pgxpool.Pool) gin.HandlerFunc {
 
 		for rows.Next() {
 			var t TeamOut
-			_ = rows.Scan(&t.ID, &t.Name, &t.IsOpen, &t.OwnerID)
+			_ = rows.Scan(&t.ID, &t.Name, &t.IsOpen, &t.OwnerID, &t.CaptainID)
 			t.Members = []UserMini{}
 			byID[t.ID] = len(teams)
 			teams = append(teams, t)
@@ -506,16 +715,6 @@ func JoinTeam(db *pgxpool.Pool) gin.HandlerFunc {
 
 		ctx := context.Background()
 
-		has, err := userHasAnyTeam(db, userID)
-		if err != nil {
-			jsonErr(c, 500, "Ошибка сервера")
-			return
-		}
-		if has {
-			jsonErr(c, 400, "Сначала выйдите из текущей команды")
-			return
-		}
-
 		tx, err := db.Begin(ctx)
 		if err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
@@ -526,7 +725,7 @@ func JoinTeam(db *pgxpool.Pool) gin.HandlerFunc {
 		var isOpen bool
 		qTeam := sq.Select("is_open").
 			From("teams").
-			Where(sq.Eq{"id": teamID}).
+			Where(sq.Eq{"id": teamID, "disbanded_at": nil}).
 			PlaceholderFormat(sq.Dollar)
 
 		if err := qRowTx(ctx, tx, qTeam).Scan(&isOpen); err != nil || !isOpen {
@@ -534,30 +733,8 @@ func JoinTeam(db *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		qCount := sq.Select("COUNT(*)").
-			From("team_members").
-			Where(sq.Eq{"team_id": teamID}).
-			PlaceholderFormat(sq.Dollar)
-
-		var count int
-		if err := qRowTx(ctx, tx, qCount).Scan(&count); err != nil {
-			jsonErr(c, 500, "Ошибка сервера")
-			return
-		}
-
-		if count >= MaxTeamMembers {
-			jsonErr(c, 400, "В команде уже максимальное количество участников (5)")
-			return
-		}
-
-		ins := sq.Insert("team_members").
-			Columns("team_id", "user_id").
-			Values(teamID, userID).
-			Suffix("ON CONFLICT DO NOTHING").
-			PlaceholderFormat(sq.Dollar)
-
-		if _, err := qExecTx(ctx, tx, ins); err != nil {
-			jsonErr(c, 500, "Ошибка сервера")
+		if err := addTeamMemberTx(ctx, tx, teamID, userID); err != nil {
+			teamMemberErr(c, err, true)
 			return
 		}
 
@@ -571,6 +748,9 @@ func JoinTeam(db *pgxpool.Pool) gin.HandlerFunc {
 	}
 }
 
+// POST /api/teams/:id/leave
+// Если уходит owner, команда переходит капитану, а без него — участнику
+// с самым ранним вступлением. Ушёл последний — команда распускается.
 func LeaveTeam(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := uid(c)
@@ -581,26 +761,114 @@ func LeaveTeam(db *pgxpool.Pool) gin.HandlerFunc {
 		}
 
 		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var ownerID int
+		qTeam := sq.Select("owner_id").
+			From("teams").
+			Where(sq.Eq{"id": teamID, "disbanded_at": nil}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qTeam).Scan(&ownerID); err != nil {
+			jsonErr(c, 404, "Команда не найдена")
+			return
+		}
+
+		locked, err := teamRosterLockedTx(ctx, tx, teamID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if locked {
+			jsonErr(c, 400, "Состав команды зафиксирован заявкой на матч")
+			return
+		}
 
 		del := sq.Delete("team_members").
 			Where(sq.Eq{"team_id": teamID, "user_id": userID}).
 			PlaceholderFormat(sq.Dollar)
 
-		if _, err := qExec(ctx, db, del); err != nil {
+		tag, err := qExecTx(ctx, tx, del)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 400, "Вы не состоите в этой команде")
+			return
+		}
+
+		action, details := "leave_team", "Пользователь вышел из команды"
+		if ownerID == userID {
+			var successor int
+			qNext := sq.Select("user_id").
+				From("team_members").
+				Where(sq.Eq{"team_id": teamID}).
+				OrderBy("is_captain DESC", "joined_at ASC", "user_id ASC").
+				Limit(1).
+				PlaceholderFormat(sq.Dollar)
+
+			err := qRowTx(ctx, tx, qNext).Scan(&successor)
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				if err := disbandTeamTx(ctx, tx, teamID); err != nil {
+					if errors.Is(err, errTeamBusy) {
+						jsonErr(c, 400, "Команда сейчас участвует в матче или турнире")
+						return
+					}
+					jsonErr(c, 500, "Ошибка сервера")
+					return
+				}
+				action, details = "disband_team", "Последний участник вышел, команда распущена"
+			case err != nil:
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			default:
+				upd := sq.Update("teams").
+					Set("owner_id", successor).
+					Where(sq.Eq{"id": teamID}).
+					PlaceholderFormat(sq.Dollar)
+
+				if _, err := qExecTx(ctx, tx, upd); err != nil {
+					jsonErr(c, 500, "Ошибка сервера")
+					return
+				}
+
+				updM := sq.Update("team_members").
+					Set("is_captain", false).
+					Where(sq.Eq{"team_id": teamID, "user_id": successor}).
+					PlaceholderFormat(sq.Dollar)
+
+				if _, err := qExecTx(ctx, tx, updM); err != nil {
+					jsonErr(c, 500, "Ошибка сервера")
+					return
+				}
+				details = "Создатель вышел, команда передана участнику"
+			}
+		}
+
+		if err := tx.Commit(ctx); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
 
-		logAction(db, &userID, "leave_team", "Пользователь вышел из команды")
+		logAction(db, &userID, action, details)
 		c.JSON(200, gin.H{"ok": true})
 	}
 }
 
-/* ===================== ✅ OWNER ADD TO CLOSED TEAM ===================== */
+/* ===================== ✅ OWNER INVITES TO CLOSED TEAM ===================== */
 
 // POST /api/teams/:id/add-user  { "user_id": 123 }
-// Только owner закрытой команды может добавлять участника.
-func OwnerAddUserToClosedTeam(db *pgxpool.Pool) gin.HandlerFunc {
+// Owner закрытой команды отправляет приглашение; пользователь вступит,
+// только когда сам его примет (GET /api/my/invites).
+func OwnerInviteToClosedTeam(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		actor := uid(c)
 		teamID, _ := strconv.Atoi(c.Param("id"))
@@ -631,7 +899,7 @@ func OwnerAddUserToClosedTeam(db *pgxpool.Pool) gin.HandlerFunc {
 		var ownerID int
 		qTeam := sq.Select("is_open", "owner_id").
 			From("teams").
-			Where(sq.Eq{"id": teamID}).
+			Where(sq.Eq{"id": teamID, "disbanded_at": nil}).
 			PlaceholderFormat(sq.Dollar)
 
 		if err := qRowTx(ctx, tx, qTeam).Scan(&isOpen, &ownerID); err != nil {
@@ -643,11 +911,11 @@ func OwnerAddUserToClosedTeam(db *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 		if ownerID != actor {
-			jsonErr(c, 403, "Только создатель закрытой команды может добавлять участников")
+			jsonErr(c, 403, "Только создатель закрытой команды может приглашать участников")
 			return
 		}
 
-		// нельзя добавлять админа
+		// нельзя приглашать админа
 		var role string
 		qRole := sq.Select("role").
 			From("users").
@@ -663,7 +931,7 @@ func OwnerAddUserToClosedTeam(db *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		// пользователь уже в какой-то команде?
+		// пользователь уже в какой-то команде? (при принятии проверяется ещё раз)
 		has, err := userHasAnyTeam(db, req.UserID)
 		if err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
@@ -674,30 +942,25 @@ func OwnerAddUserToClosedTeam(db *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		// лимит участников
-		qCount := sq.Select("COUNT(*)").
-			From("team_members").
-			Where(sq.Eq{"team_id": teamID}).
+		// старое неотвеченное приглашение заменяем новым
+		upd := sq.Update("team_invites").
+			Set("status", "expired").
+			Where(sq.Eq{"team_id": teamID, "user_id": req.UserID, "status": "pending"}).
 			PlaceholderFormat(sq.Dollar)
 
-		var count int
-		if err := qRowTx(ctx, tx, qCount).Scan(&count); err != nil {
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
-		if count >= MaxTeamMembers {
-			jsonErr(c, 400, "В команде уже максимальное количество участников (5)")
-			return
-		}
 
-		// добавляем
-		ins := sq.Insert("team_members").
-			Columns("team_id", "user_id").
-			Values(teamID, req.UserID).
-			Suffix("ON CONFLICT DO NOTHING").
+		var inviteID int
+		ins := sq.Insert("team_invites").
+			Columns("team_id", "user_id", "invited_by", "expires_at").
+			Values(teamID, req.UserID, actor, sq.Expr(sqlNowUTC+" + make_interval(hours => ?)", TeamInviteTTLHours)).
+			Suffix("RETURNING id").
 			PlaceholderFormat(sq.Dollar)
 
-		if _, err := qExecTx(ctx, tx, ins); err != nil {
+		if err := qRowTx(ctx, tx, ins).Scan(&inviteID); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
@@ -707,8 +970,8 @@ func OwnerAddUserToClosedTeam(db *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		logAction(db, &actor, "owner_add_user_to_team", "Создатель добавил пользователя в закрытую команду")
-		c.JSON(200, gin.H{"ok": true})
+		logAction(db, &actor, "owner_invite_to_team", "Создатель пригласил пользователя в закрытую команду")
+		c.JSON(200, gin.H{"ok": true, "invite_id": inviteID})
 	}
 }
 
@@ -762,7 +1025,7 @@ func AdminUsers(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
 
-		q := sq.Select("id", "username", "role", "points").
+		q := sq.Select("id", "username", "role", "points", "rating").
 			From("users").
 			OrderBy("id ASC").
 			PlaceholderFormat(sq.Dollar)
@@ -777,7 +1040,7 @@ func AdminUsers(db *pgxpool.Pool) gin.HandlerFunc {
 		var out []User
 		for rows.Next() {
 			var u User
-			_ = rows.Scan(&u.ID, &u.Username, &u.Role, &u.Points)
+			_ = rows.Scan(&u.ID, &u.Username, &u.Role, &u.Points, &u.Rating)
 			out = append(out, u)
 		}
 		c.JSON(200, out)
@@ -803,10 +1066,16 @@ func AdminDeleteUser(db *pgxpool.Pool) gin.HandlerFunc {
 			Where(sq.Eq{"id": id}).
 			PlaceholderFormat(sq.Dollar)
 
-		if _, err := qExec(ctx, db, del); err != nil {
+		tag, err := qExec(ctx, db, del)
+		if err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 404, "Пользователь не найден")
+			return
+		}
+		invalidateUser(id)
 
 		logAction(db, &actor, "admin_delete_user", "Администратор удалил пользователя")
 		c.JSON(200, gin.H{"ok": true})
@@ -835,13 +1104,32 @@ func AdminSetPoints(db *pgxpool.Pool) gin.HandlerFunc {
 		}
 
 		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
 
-		upd := sq.Update("users").
-			Set("points", req.Points).
+		// новое значение пишем в журнал разницей с текущим
+		var current int
+		q := sq.Select("points").
+			From("users").
 			Where(sq.Eq{"id": id}).
+			Suffix("FOR UPDATE").
 			PlaceholderFormat(sq.Dollar)
 
-		if _, err := qExec(ctx, db, upd); err != nil {
+		if err := qRowTx(ctx, tx, q).Scan(&current); err != nil {
+			jsonErr(c, 404, "Пользователь не найден")
+			return
+		}
+
+		if err := addPointsTx(ctx, tx, []int{id}, req.Points-current, reasonAdminSet, nil, &actor); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
@@ -858,8 +1146,11 @@ func AdminCreateMatch(db *pgxpool.Pool) gin.HandlerFunc {
 		actor := uid(c)
 
 		var req struct {
-			Title string `json:"title"`
-			Mode  string `json:"mode"`
+			Title    string     `json:"title"`
+			Mode     string     `json:"mode"`
+			StartsAt *time.Time `json:"starts_at"`
+			EndsAt   *time.Time `json:"ends_at"`
+			MatchRules
 		}
 		if err := c.BindJSON(&req); err != nil {
 			jsonErr(c, 400, "Некорректные данные")
@@ -872,12 +1163,28 @@ func AdminCreateMatch(db *pgxpool.Pool) gin.HandlerFunc {
 			jsonErr(c, 400, "Некорректные данные")
 			return
 		}
+		if !req.MatchRules.normalize() {
+			jsonErr(c, 400, "Некорректные ограничения матча")
+			return
+		}
+		if !validSchedule(req.StartsAt, req.EndsAt) {
+			jsonErr(c, 400, "Время окончания должно быть позже начала")
+			return
+		}
 
 		ctx := context.Background()
 
+		cols := req.MatchRules.setMap()
+		cols["title"] = req.Title
+		cols["mode"] = req.Mode
+		cols["status"] = "open"
+		cols["created_by"] = actor
+		cols["starts_at"] = utcPtr(req.StartsAt)
+		cols["ends_at"] = utcPtr(req.EndsAt)
+		cols["season_id"] = sq.Expr(currentSeasonSQL)
+
 		ins := sq.Insert("matches").
-			Columns("title", "mode", "status", "created_by").
-			Values(req.Title, req.Mode, "open", actor).
+			SetMap(cols).
 			PlaceholderFormat(sq.Dollar)
 
 		if _, err := qExec(ctx, db, ins); err != nil {
@@ -900,8 +1207,11 @@ func AdminUpdateMatch(db *pgxpool.Pool) gin.HandlerFunc {
 		}
 
 		var req struct {
-			Title string `json:"title"`
-			Mode  string `json:"mode"`
+			Title    string     `json:"title"`
+			Mode     string     `json:"mode"`
+			StartsAt *time.Time `json:"starts_at"`
+			EndsAt   *time.Time `json:"ends_at"`
+			MatchRules
 		}
 		if err := c.BindJSON(&req); err != nil {
 			jsonErr(c, 400, "Некорректные данные")
@@ -914,27 +1224,77 @@ func AdminUpdateMatch(db *pgxpool.Pool) gin.HandlerFunc {
 			jsonErr(c, 400, "Некорректные данные")
 			return
 		}
+		if !req.MatchRules.normalize() {
+			jsonErr(c, 400, "Некорректные ограничения матча")
+			return
+		}
+		if !validSchedule(req.StartsAt, req.EndsAt) {
+			jsonErr(c, 400, "Время окончания должно быть позже начала")
+			return
+		}
 
 		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// блокировка — чтобы планировщик не закрыл матч между проверкой и записью
+		var st, curTitle, curMode string
+		var tournamentID *int
+		var cur MatchRules
+		qSt := sq.Select("status", "title", "mode", "tournament_id").
+			Columns(ruleColumns("")...).
+			From("matches").
+			Where(sq.Eq{"id": id}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
 
-		var st string
-		qSt := sq.Select("status").From("matches").Where(sq.Eq{"id": id}).PlaceholderFormat(sq.Dollar)
-		if err := qRow(ctx, db, qSt).Scan(&st); err != nil {
+		if err := qRowTx(ctx, tx, qSt).Scan(append([]any{&st, &curTitle, &curMode, &tournamentID}, cur.dest()...)...); err != nil {
 			jsonErr(c, 404, "Матч не найден")
 			return
 		}
-		if st != "open" {
+		if tournamentID != nil && curMode != req.Mode {
+			jsonErr(c, 400, "Режим турнирного матча задаётся турниром")
+			return
+		}
+		if st != "open" && st != "closed" {
 			jsonErr(c, 400, "Нельзя изменять завершённый матч")
 			return
 		}
+		// у идущего матча можно только сдвинуть расписание (продлить матч
+		// или перенести начало перед повторным открытием регистрации)
+		if st == "closed" && (req.Title != curTitle || req.Mode != curMode || req.MatchRules != cur) {
+			jsonErr(c, 400, "У идущего матча можно изменить только расписание")
+			return
+		}
 
 		upd := sq.Update("matches").
-			Set("title", req.Title).
-			Set("mode", req.Mode).
+			Set("starts_at", utcPtr(req.StartsAt)).
+			Set("ends_at", utcPtr(req.EndsAt)).
 			Where(sq.Eq{"id": id}).
 			PlaceholderFormat(sq.Dollar)
 
-		if _, err := qExec(ctx, db, upd); err != nil {
+		if st == "open" {
+			upd = upd.Set("title", req.Title).
+				Set("mode", req.Mode).
+				SetMap(req.MatchRules.setMap())
+		}
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		// лимит мест мог вырасти — поднимаем лист ожидания
+		if st == "open" {
+			if err := promoteWaitlistTx(ctx, tx, id); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
@@ -955,20 +1315,104 @@ func AdminDeleteMatch(db *pgxpool.Pool) gin.HandlerFunc {
 
 		ctx := context.Background()
 
+		// матчи турнира удаляются только вместе с турниром, иначе порвётся сетка
 		del := sq.Delete("matches").
 			Where(sq.Eq{"id": id}).
+			Where(sq.Expr("tournament_id IS NULL")).
 			PlaceholderFormat(sq.Dollar)
 
-		if _, err := qExec(ctx, db, del); err != nil {
+		tag, err := qExec(ctx, db, del)
+		if err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
 		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 400, "Матч не найден или входит в турнир")
+			return
+		}
 
 		logAction(db, &actor, "admin_delete_match", "Администратор удалил матч")
 		c.JSON(200, gin.H{"ok": true})
 	}
 }
 
+// POST /api/admin/matches/:id/status  { "status": "open" | "closed" }
+func AdminSetMatchStatus(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		var req struct {
+			Status string `json:"status"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		to := normStatus(req.Status)
+		if to == "finished" {
+			jsonErr(c, 400, "Для завершения матча выберите победителя или подведите итоги")
+			return
+		}
+		if to != "open" && to != "closed" {
+			jsonErr(c, 400, "Некорректный статус")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var from, title string
+		var closesAt *time.Time
+		qM := sq.Select("status", "title", "COALESCE(starts_at, ends_at)").
+			From("matches").
+			Where(sq.Eq{"id": id}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qM).Scan(&from, &title, &closesAt); err != nil {
+			jsonErr(c, 404, "Матч не найден")
+			return
+		}
+		if !canMoveMatch(from, to) {
+			jsonErr(c, 400, "Недопустимый переход статуса")
+			return
+		}
+		// иначе планировщик сразу закроет регистрацию снова: он смотрит на
+		// starts_at, а без него — на ends_at
+		if to == "open" && closesAt != nil && !closesAt.After(time.Now().UTC()) {
+			jsonErr(c, 400, "Расписание матча уже прошло — измените его")
+			return
+		}
+
+		upd := sq.Update("matches").
+			Set("status", to).
+			Where(sq.Eq{"id": id, "status": from}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_match_status", "Статус матча "+clampRunes(title, MaxMatchTitle)+": "+from+" -> "+to)
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
 func AdminListMatches(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		status := normStatus(c.Query("status"))
@@ -979,7 +1423,8 @@ func AdminListMatches(db *pgxpool.Pool) gin.HandlerFunc {
 
 		ctx := context.Background()
 
-		q := sq.Select("id", "title", "mode", "status").
+		q := sq.Select("id", "title", "mode", "status", "starts_at", "ends_at", "tournament_id").
+			Columns(ruleColumns("")...).
 			From("matches").
 			OrderBy("id DESC").
 			Limit(500).
@@ -999,7 +1444,7 @@ func AdminListMatches(db *pgxpool.Pool) gin.HandlerFunc {
 		var out []Match
 		for rows.Next() {
 			var m Match
-			_ = rows.Scan(&m.ID, &m.Title, &m.Mode, &m.Status)
+			_ = rows.Scan(append([]any{&m.ID, &m.Title, &m.Mode, &m.Status, &m.StartsAt, &m.EndsAt, &m.TournamentID}, m.dest()...)...)
 			out = append(out, m)
 		}
 		c.JSON(200, out)
@@ -1018,6 +1463,7 @@ func AdminListApplications(db *pgxpool.Pool) gin.HandlerFunc {
 			"u.username",
 			"COALESCE(t.name,'')",
 			"a.status",
+			"ARRAY(SELECT ru.username FROM application_roster r JOIN users ru ON ru.id = r.user_id WHERE r.application_id = a.id ORDER BY ru.username)",
 		).
 			From("applications a").
 			Join("matches m ON m.id = a.match_id").
@@ -1037,14 +1483,15 @@ func AdminListApplications(db *pgxpool.Pool) gin.HandlerFunc {
 			ID     int64  `json:"id"`
 			Match  string `json:"match"`
 			User   string `json:"user"`
-			Team   string `json:"team"`
-			Status string `json:"status"`
+			Team   string   `json:"team"`
+			Status string   `json:"status"`
+			Roster []string `json:"roster"` // состав, зафиксированный при подаче
 		}
 
 		var out []outRow
 		for rows.Next() {
 			var r outRow
-			_ = rows.Scan(&r.ID, &r.Match, &r.User, &r.Team, &r.Status)
+			_ = rows.Scan(&r.ID, &r.Match, &r.User, &r.Team, &r.Status, &r.Roster)
 			out = append(out, r)
 		}
 		c.JSON(200, out)
@@ -1076,10 +1523,18 @@ func AdminApproveApplication(db *pgxpool.Pool) gin.HandlerFunc {
 			jsonErr(c, 404, "Заявка не найдена")
 			return
 		}
+		if strings.ToLower(st) == "waitlisted" {
+			jsonErr(c, 400, "Заявка в листе ожидания — мест нет")
+			return
+		}
 		if strings.ToLower(st) != "pending" {
 			jsonErr(c, 400, "Решение уже принято")
 			return
 		}
+		if mStatus == "closed" {
+			jsonErr(c, 400, "Состав матча уже зафиксирован")
+			return
+		}
 		if mStatus != "open" {
 			jsonErr(c, 400, "Матч уже завершён")
 			return
@@ -1112,45 +1567,13 @@ func AdminApproveApplication(db *pgxpool.Pool) gin.HandlerFunc {
 				return
 			}
 		} else {
-			// ✅ team: добавляем ВСЕХ участников команды
-			qMembers := sq.Select("user_id").
-				From("team_members").
-				Where(sq.Eq{"team_id": *teamID}).
-				PlaceholderFormat(sq.Dollar)
-
-			rows, err := qQueryTx(ctx, tx, qMembers)
-			if err != nil {
+			// ✅ team: в матч идёт состав, зафиксированный при подаче заявки
+			if err := addRosterToMatchTx(ctx, tx, appID, matchID, *teamID); err != nil {
 				jsonErr(c, 500, "Ошибка сервера")
 				return
 			}
-			defer rows.Close()
-
-			members := make([]int, 0, 8)
-			for rows.Next() {
-				var id int
-				_ = rows.Scan(&id)
-				if id > 0 {
-					members = append(members, id)
-				}
-			}
-
-			// вставляем каждого участника команды в match_participants
-			// (без raw SQL — всё через squirrel)
-			for _, uid2 := range members {
-				ins := sq.Insert("match_participants").
-					Columns("match_id", "user_id", "team_id").
-					Values(matchID, uid2, *teamID).
-					Suffix("ON CONFLICT DO NOTHING").
-					PlaceholderFormat(sq.Dollar)
-
-				if _, err := qExecTx(ctx, tx, ins); err != nil {
-					jsonErr(c, 500, "Ошибка сервера")
-					return
-				}
-			}
 		}
 
-
 		if err := tx.Commit(ctx); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
 			return
@@ -1234,54 +1657,54 @@ func AdminRejectApplication(db *pgxpool.Pool) gin.HandlerFunc {
 		}
 
 		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
 
 		var matchID, userID int
 		var teamID *int
 		var st, mStatus string
 
+		// заявка и матч блокируются: параллельные одобрение, отказ или отзыв
+		// не должны дважды освободить место
 		qApp := sq.Select("a.match_id", "a.user_id", "a.team_id", "a.status", "m.status").
 			From("applications a").
 			Join("matches m ON m.id = a.match_id").
 			Where(sq.Eq{"a.id": appID}).
+			Suffix("FOR UPDATE OF a, m").
 			PlaceholderFormat(sq.Dollar)
 
-		if err := qRow(ctx, db, qApp).Scan(&matchID, &userID, &teamID, &st, &mStatus); err != nil {
+		if err := qRowTx(ctx, tx, qApp).Scan(&matchID, &userID, &teamID, &st, &mStatus); err != nil {
 			jsonErr(c, 404, "Заявка не найдена")
 			return
 		}
-		if strings.ToLower(st) != "pending" {
-			jsonErr(c, 400, "Решение уже принято")
+		switch strings.ToLower(st) {
+		case "rejected":
+			jsonErr(c, 400, "Заявка уже отклонена")
+			return
+		case "withdrawn":
+			jsonErr(c, 400, "Заявка отозвана")
 			return
 		}
-		if mStatus != "open" {
+		if mStatus == "finished" {
 			jsonErr(c, 400, "Матч уже завершён")
 			return
 		}
-
-		tx, err := db.Begin(ctx)
-		if err != nil {
-			jsonErr(c, 500, "Ошибка сервера")
+		// состав идущего матча не меняется
+		if st == "approved" && mStatus != "open" {
+			jsonErr(c, 400, "Нельзя исключить участника идущего матча")
 			return
 		}
-		defer tx.Rollback(ctx)
-
-		upd := sq.Update("applications").
-			Set("status", "rejected").
-			Where(sq.Eq{"id": appID}).
-			PlaceholderFormat(sq.Dollar)
-		_, _ = qExecTx(ctx, tx, upd)
 
-		var del sq.DeleteBuilder
-		if teamID != nil {
-			del = sq.Delete("match_participants").
-				Where(sq.Eq{"match_id": matchID, "user_id": userID, "team_id": *teamID}).
-				PlaceholderFormat(sq.Dollar)
-		} else {
-			del = sq.Delete("match_participants").
-				Where(sq.Eq{"match_id": matchID, "user_id": userID}).
-				PlaceholderFormat(sq.Dollar)
+		// одобренная заявка тоже может быть отклонена — освободившееся
+		// место получает первый из листа ожидания
+		if err := releaseApplicationTx(ctx, tx, appID, matchID, userID, teamID, "rejected"); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
 		}
-		_, _ = qExecTx(ctx, tx, del)
 
 		if err := tx.Commit(ctx); err != nil {
 			jsonErr(c, 500, "Ошибка сервера")
@@ -1314,7 +1737,7 @@ func AdminMatchParticipants(db *pgxpool.Pool) gin.HandlerFunc {
 			jsonErr(c, 404, "Матч не найден")
 			return
 		}
-		if status != "open" {
+		if status == "finished" {
 			jsonErr(c, 400, "Матч завершён. Доступен только отчёт.")
 			return
 		}
@@ -1457,6 +1880,7 @@ func AdminSetWinner(db *pgxpool.Pool) gin.HandlerFunc {
 		qM := sq.Select("status", "title").
 			From("matches").
 			Where(sq.Eq{"id": matchID}).
+			Suffix("FOR UPDATE").
 			PlaceholderFormat(sq.Dollar)
 
 		if err := qRowTx(ctx, tx, qM).Scan(&mStatus, &mTitle); err != nil {
@@ -1503,64 +1927,26 @@ func AdminSetWinner(db *pgxpool.Pool) gin.HandlerFunc {
 			}
 		}
 
-		updM := sq.Update("matches").
-			Set("status", "finished").
-			Set("winner_user_id", req.WinnerUserID).
-			Set("winner_team_id", req.WinnerTeamID).
-			Where(sq.Eq{"id": matchID}).
-			PlaceholderFormat(sq.Dollar)
-
-		if _, err := qExecTx(ctx, tx, updM); err != nil {
-			jsonErr(c, 500, "Ошибка сервера")
-			return
+		var winner entrant
+		if req.WinnerUserID != nil {
+			winner = entrant{UserID: *req.WinnerUserID}
+		} else {
+			winner = entrant{TeamID: *req.WinnerTeamID}
 		}
 
-		// начисление очков
+		// очки за задания начисляются всем, бонус — только победителю
+		extra := map[entrant]int{}
 		if req.BonusPoints > 0 {
-			if req.WinnerUserID != nil {
-				updU := sq.Update("users").
-					Set("points", sq.Expr("points + ?", req.BonusPoints)).
-					Where(sq.Eq{"id": *req.WinnerUserID}).
-					PlaceholderFormat(sq.Dollar)
-
-				if _, err := qExecTx(ctx, tx, updU); err != nil {
-					jsonErr(c, 500, "Ошибка сервера")
-					return
-				}
-			} else {
-				qMembers := sq.Select("user_id").
-					From("team_members").
-					Where(sq.Eq{"team_id": *req.WinnerTeamID}).
-					PlaceholderFormat(sq.Dollar)
-
-				rows, err := qQueryTx(ctx, tx, qMembers)
-				if err != nil {
-					jsonErr(c, 500, "Ошибка сервера")
-					return
-				}
-				defer rows.Close()
-
-				ids := make([]int, 0, 16)
-				for rows.Next() {
-					var id int
-					_ = rows.Scan(&id)
-					if id > 0 {
-						ids = append(ids, id)
-					}
-				}
-
-				if len(ids) > 0 {
-					updTeam := sq.Update("users").
-						Set("points", sq.Expr("points + ?", req.BonusPoints)).
-						Where(sq.Eq{"id": ids}).
-						PlaceholderFormat(sq.Dollar)
+			extra[winner] = req.BonusPoints
+		}
 
-					if _, err := qExecTx(ctx, tx, updTeam); err != nil {
-						jsonErr(c, 500, "Ошибка сервера")
-						return
-					}
-				}
+		if err := finishMatchTx(ctx, tx, &actor, matchID, winner, extra); err != nil {
+			if errors.Is(err, errMatchFinished) {
+				jsonErr(c, 400, "Матч уже завершён")
+				return
 			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
 		}
 
 		if err := tx.Commit(ctx); err != nil {
@@ -1613,6 +1999,10 @@ func AdminMatchReport(db *pgxpool.Pool) gin.HandlerFunc {
 				return "Одобрена"
 			case "rejected":
 				return "Отклонена"
+			case "waitlisted":
+				return "Лист ожидания"
+			case "withdrawn":
+				return "Отозвана"
 			default:
 				return s
 			}
@@ -1714,6 +2104,21 @@ func AdminMatchReport(db *pgxpool.Pool) gin.HandlerFunc {
 			}
 		}
 
+		// таблица по заданиям (если в матче были решения)
+		if tx, err := db.Begin(ctx); err == nil {
+			board, err := loadScoreboardTx(ctx, tx, matchID)
+			_ = tx.Rollback(ctx)
+			if err == nil && len(board) > 0 && board[0].Score > 0 {
+				report += "\nТаблица:\n"
+				for i, e := range board {
+					if i >= 10 {
+						break
+					}
+					report += strconv.Itoa(e.Rank) + ". " + clampRunes(e.Name, MaxReportLine) + " — " + strconv.Itoa(e.Score) + "\n"
+				}
+			}
+		}
+
 		c.JSON(200, gin.H{"report": report})
 	}
 }
@@ -1732,6 +2137,7 @@ func AdminListTeams(db *pgxpool.Pool) gin.HandlerFunc {
 		).
 			From("teams t").
 			LeftJoin("team_members tm ON tm.team_id = t.id").
+			Where(sq.Eq{"t.disbanded_at": nil}).
 			GroupBy("t.id", "t.name", "t.is_open").
 			OrderBy("t.id DESC").
 			PlaceholderFormat(sq.Dollar)
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Неудачные входы считаются отдельно по логину и по IP. Первые попытки
+// бесплатны, дальше каждая неудача удваивает паузу до следующей попытки,
+// а после порога ключ блокируется на LoginLockoutMinutes. Счётчик
+// обнуляется, если неудач не было дольше LoginAttemptWindowMinutes.
+type attemptPolicy struct {
+	free int // неудач без паузы
+	lock int // неудач до блокировки
+}
+
+var attemptPolicies = map[string]attemptPolicy{
+	"user": {free: 3, lock: 10},
+	"ip":   {free: 10, lock: 50}, // за одним IP может быть много игроков
+}
+
+// maxBackoffShift — дальше удвоение паузы не считается.
+const maxBackoffShift = 20
+
+func loginUserKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// loginDelay — пауза после failures неудач подряд.
+func loginDelay(p attemptPolicy, failures int) time.Duration {
+	limit := LoginLockoutMinutes * time.Minute
+	if failures >= p.lock {
+		return limit
+	}
+	if failures < p.free {
+		return 0
+	}
+	// большой сдвиг переполняет Duration — к этому моменту пауза всё
+	// равно давно упёрлась в предел
+	shift := failures - p.free
+	if shift > maxBackoffShift {
+		return limit
+	}
+	d := time.Duration(LoginBackoffBaseSeconds) * time.Second << shift
+	if d > limit {
+		return limit
+	}
+	return d
+}
+
+// loginRetryAfter — сколько секунд ждать до следующей попытки по логину
+// или IP; 0 — можно пробовать.
+func loginRetryAfter(ctx context.Context, db *pgxpool.Pool, username, ip string) (int, error) {
+	q := sq.Select("COALESCE(MAX(CEIL(EXTRACT(EPOCH FROM locked_until - " + sqlNowUTC + "))), 0)::int").
+		From("login_attempts").
+		Where(sq.Or{
+			sq.Eq{"kind": "user", "key": loginUserKey(username)},
+			sq.Eq{"kind": "ip", "key": ip},
+		}).
+		Where(sq.Expr("locked_until > " + sqlNowUTC)).
+		PlaceholderFormat(sq.Dollar)
+
+	var secs int
+	err := qRow(ctx, db, q).Scan(&secs)
+	return secs, err
+}
+
+// recordLoginFailure учитывает неудачу по логину и IP и пишет её в logs.
+// userID — если логин существует.
+func recordLoginFailure(ctx context.Context, db *pgxpool.Pool, userID *int, username, ip string) error {
+	keys := map[string]string{"user": loginUserKey(username), "ip": ip}
+	for kind, key := range keys {
+		ins := sq.Insert("login_attempts").
+			Columns("kind", "key", "failures", "last_failed_at").
+			Values(kind, key, 1, sq.Expr(sqlNowUTC)).
+			Suffix("ON CONFLICT (kind, key) DO UPDATE SET "+
+				"failures = CASE WHEN login_attempts.last_failed_at < "+sqlNowUTC+" - make_interval(mins => ?) "+
+				"THEN 1 ELSE login_attempts.failures + 1 END, "+
+				"last_failed_at = EXCLUDED.last_failed_at RETURNING failures", LoginAttemptWindowMinutes).
+			PlaceholderFormat(sq.Dollar)
+
+		var failures int
+		if err := qRow(ctx, db, ins).Scan(&failures); err != nil {
+			return err
+		}
+
+		d := loginDelay(attemptPolicies[kind], failures)
+		if d <= 0 {
+			continue
+		}
+		upd := sq.Update("login_attempts").
+			Set("locked_until", sq.Expr(sqlNowUTC+" + make_interval(secs => ?)", int(d/time.Second))).
+			Where(sq.Eq{"kind": kind, "key": key}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExec(ctx, db, upd); err != nil {
+			return err
+		}
+	}
+
+	logAction(db, userID, "login_failed", "Неудачный вход: "+clampRunes(username, MaxReportLine)+" с "+ip)
+	return nil
+}
+
+// clearLoginFailures — полностью успешный вход (с 2FA — после кода)
+// снимает счётчик логина. Счётчик IP остаётся: иначе подбор чужих паролей
+// можно чередовать со входом в свой аккаунт.
+func clearLoginFailures(ctx context.Context, db *pgxpool.Pool, username string) {
+	del := sq.Delete("login_attempts").
+		Where(sq.Eq{"kind": "user", "key": loginUserKey(username)}).
+		PlaceholderFormat(sq.Dollar)
+
+	_, _ = qExec(ctx, db, del)
+}
+
+/* ===================== ADMIN: LOCKOUTS ===================== */
+
+// GET /api/admin/lockouts?all=1
+// Заблокированные сейчас логины и IP; all=1 — все с неудачами в окне.
+func AdminLockouts(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		q := sq.Select("kind", "key", "failures", "last_failed_at", "locked_until").
+			Column(sq.Expr("COALESCE(locked_until > " + sqlNowUTC + ", false)")).
+			From("login_attempts").
+			OrderBy("last_failed_at DESC").
+			Limit(MaxPage).
+			PlaceholderFormat(sq.Dollar)
+
+		if c.Query("all") == "1" {
+			q = q.Where(sq.Expr("last_failed_at > "+sqlNowUTC+" - make_interval(mins => ?)", LoginAttemptWindowMinutes))
+		} else {
+			q = q.Where(sq.Expr("locked_until > " + sqlNowUTC))
+		}
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []LoginLockout{}
+		for rows.Next() {
+			var l LoginLockout
+			if err := rows.Scan(&l.Kind, &l.Key, &l.Failures, &l.LastFailedAt, &l.LockedUntil, &l.Locked); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			out = append(out, l)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// DELETE /api/admin/lockouts?kind=user|ip&key=...
+func AdminClearLockout(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		kind := c.Query("kind")
+		key := strings.TrimSpace(c.Query("key"))
+		if _, ok := attemptPolicies[kind]; !ok || key == "" {
+			jsonErr(c, 400, "Некорректная блокировка")
+			return
+		}
+		if kind == "user" {
+			key = loginUserKey(key)
+		}
+
+		ctx := context.Background()
+		del := sq.Delete("login_attempts").
+			Where(sq.Eq{"kind": kind, "key": key}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExec(ctx, db, del)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 404, "Блокировка не найдена")
+			return
+		}
+
+		logAction(db, &actor, "admin_clear_lockout", "Снята блокировка входа: "+kind+" "+clampRunes(key, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	limit := LoginLockoutMinutes * time.Minute
+	base := time.Duration(LoginBackoffBaseSeconds) * time.Second
+
+	for kind, p := range attemptPolicies {
+		prev := time.Duration(0)
+		for f := 0; f <= p.lock; f++ {
+			d := loginDelay(p, f)
+
+			switch {
+			case f < p.free:
+				if d != 0 {
+					t.Errorf("%s: failures=%d: delay %v, want 0", kind, f, d)
+				}
+			case f >= p.lock:
+				if d != limit {
+					t.Errorf("%s: failures=%d: delay %v, want lockout %v", kind, f, d, limit)
+				}
+			default:
+				if d <= 0 || d > limit {
+					t.Errorf("%s: failures=%d: delay %v out of (0, %v]", kind, f, d, limit)
+				}
+			}
+			if d < prev {
+				t.Errorf("%s: failures=%d: delay %v less than previous %v", kind, f, d, prev)
+			}
+			prev = d
+		}
+	}
+
+	tests := []struct {
+		name     string
+		p        attemptPolicy
+		failures int
+		want     time.Duration
+	}{
+		{"first paid failure", attemptPolicies["user"], 3, base},
+		{"doubles", attemptPolicies["user"], 5, 4 * base},
+		{"lockout", attemptPolicies["user"], 10, limit},
+		{"ip before overflow", attemptPolicies["ip"], 44, limit},
+		{"ip just under lock", attemptPolicies["ip"], 49, limit},
+		{"huge count", attemptPolicy{free: 0, lock: 1000}, 999, limit},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.p, tt.failures); got != tt.want {
+			t.Errorf("%s: loginDelay(%d) = %v, want %v", tt.name, tt.failures, got, tt.want)
+		}
+	}
+}
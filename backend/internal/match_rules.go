@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var errRosterLocked = errors.New("team roster is locked")
+
+/* ===================== MATCH RULES ===================== */
+
+// ruleColumns — колонки MatchRules в порядке MatchRules.dest().
+func ruleColumns(prefix string) []string {
+	cols := []string{"min_team_size", "max_team_size", "max_participants", "min_rating", "invite_only", "allow_solo"}
+	for i := range cols {
+		cols[i] = prefix + cols[i]
+	}
+	return cols
+}
+
+func (r *MatchRules) dest() []any {
+	return []any{&r.MinTeamSize, &r.MaxTeamSize, &r.MaxParticipants, &r.MinRating, &r.InviteOnly, &r.AllowSolo}
+}
+
+func (r *MatchRules) normalize() bool {
+	if r.MinTeamSize == 0 {
+		r.MinTeamSize = 1
+	}
+	if r.MaxTeamSize == 0 {
+		r.MaxTeamSize = MaxTeamMembers
+	}
+	if r.MinTeamSize < 1 || r.MinTeamSize > r.MaxTeamSize || r.MaxTeamSize > MaxTeamMembers {
+		return false
+	}
+	return r.MaxParticipants >= 0 && r.MaxParticipants <= MaxMatchParticipants &&
+		r.MinRating >= 0 && r.MinRating <= MaxMinRating
+}
+
+func (r MatchRules) setMap() map[string]any {
+	return map[string]any{
+		"min_team_size":    r.MinTeamSize,
+		"max_team_size":    r.MaxTeamSize,
+		"max_participants": r.MaxParticipants,
+		"min_rating":       r.MinRating,
+		"invite_only":      r.InviteOnly,
+		"allow_solo":       r.AllowSolo,
+	}
+}
+
+func teamSizeErr(r MatchRules) string {
+	if r.MinTeamSize == r.MaxTeamSize {
+		return "В команде должно быть ровно " + strconv.Itoa(r.MinTeamSize) + " игроков"
+	}
+	return "В команде должно быть от " + strconv.Itoa(r.MinTeamSize) + " до " + strconv.Itoa(r.MaxTeamSize) + " игроков"
+}
+
+// checkEligibilityTx проверяет условия матча для заявки игрока (teamID == nil)
+// или команды. Возвращает текст отказа; "" — можно подавать. Лимит мест
+// не отказ: сверх него заявка попадает в лист ожидания.
+func checkEligibilityTx(ctx context.Context, tx pgx.Tx, matchID int, r MatchRules, userID int, teamID *int) (string, error) {
+	if r.InviteOnly {
+		inv := sq.Select("1").From("match_invites").Where(sq.Eq{"match_id": matchID})
+		if teamID != nil {
+			inv = inv.Where(sq.Eq{"team_id": *teamID})
+		} else {
+			inv = inv.Where(sq.Eq{"user_id": userID})
+		}
+		q := sq.Select().Column(sq.Expr("EXISTS(?)", inv)).PlaceholderFormat(sq.Dollar)
+
+		var invited bool
+		if err := qRowTx(ctx, tx, q).Scan(&invited); err != nil {
+			return "", err
+		}
+		if !invited {
+			return "Участие в матче только по приглашению", nil
+		}
+	}
+
+	if r.MinRating > 0 {
+		q := sq.Select("rating").From("users").Where(sq.Eq{"id": userID}).PlaceholderFormat(sq.Dollar)
+		if teamID != nil {
+			q = sq.Select("rating").From("teams").Where(sq.Eq{"id": *teamID}).PlaceholderFormat(sq.Dollar)
+		}
+
+		var rating int
+		if err := qRowTx(ctx, tx, q).Scan(&rating); err != nil {
+			return "", err
+		}
+		if rating < r.MinRating {
+			return "Нужен рейтинг не ниже " + strconv.Itoa(r.MinRating), nil
+		}
+	}
+
+	if teamID != nil {
+		var count int
+		q := sq.Select("COUNT(*)").
+			From("team_members").
+			Where(sq.Eq{"team_id": *teamID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&count); err != nil {
+			return "", err
+		}
+		if count < r.MinTeamSize || count > r.MaxTeamSize {
+			return teamSizeErr(r), nil
+		}
+	}
+
+	return "", nil
+}
+
+/* ===================== ROSTER LOCK ===================== */
+
+// teamRosterLockedTx — состав команды зафиксирован: у неё есть действующая
+// заявка на ещё не завершённый матч, либо она сейчас играет (teamBusyTx).
+// Пока так, вступать, выходить и исключать участников нельзя.
+func teamRosterLockedTx(ctx context.Context, tx pgx.Tx, teamID int) (bool, error) {
+	busy, err := teamBusyTx(ctx, tx, teamID)
+	if err != nil || busy {
+		return busy, err
+	}
+
+	sub := sq.Select("1").
+		From("applications a").
+		Join("matches m ON m.id = a.match_id").
+		Where(sq.Eq{"a.team_id": teamID, "a.status": []string{"pending", "approved", "waitlisted"}}).
+		Where(sq.NotEq{"m.status": "finished"})
+
+	q := sq.Select().
+		Column(sq.Expr("EXISTS(?)", sub)).
+		PlaceholderFormat(sq.Dollar)
+
+	var locked bool
+	err = qRowTx(ctx, tx, q).Scan(&locked)
+	return locked, err
+}
+
+// addRosterToMatchTx переносит зафиксированный при подаче заявки состав
+// в участники матча. Для заявок, поданных до появления составов, берётся
+// текущий состав команды.
+func addRosterToMatchTx(ctx context.Context, tx pgx.Tx, appID, matchID, teamID int) error {
+	captured := sq.Select("1").From("application_roster").Where(sq.Eq{"application_id": appID})
+	qHas := sq.Select().Column(sq.Expr("EXISTS(?)", captured)).PlaceholderFormat(sq.Dollar)
+
+	var has bool
+	if err := qRowTx(ctx, tx, qHas).Scan(&has); err != nil {
+		return err
+	}
+
+	var roster sq.SelectBuilder
+	if has {
+		roster = sq.Select().
+			Column(sq.Expr("?::int", matchID)).
+			Column("user_id").
+			Column(sq.Expr("?::int", teamID)).
+			From("application_roster").
+			Where(sq.Eq{"application_id": appID})
+	} else {
+		roster = sq.Select().
+			Column(sq.Expr("?::int", matchID)).
+			Columns("user_id", "team_id").
+			From("team_members").
+			Where(sq.Eq{"team_id": teamID})
+	}
+
+	ins := sq.Insert("match_participants").
+		Columns("match_id", "user_id", "team_id").
+		Select(roster).
+		Suffix("ON CONFLICT DO NOTHING").
+		PlaceholderFormat(sq.Dollar)
+
+	_, err := qExecTx(ctx, tx, ins)
+	return err
+}
+
+/* ===================== ADMIN: MATCH INVITES ===================== */
+
+// GET /api/admin/matches/:id/invites
+func AdminListMatchInvites(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		ctx := context.Background()
+
+		q := sq.Select("i.id", "i.user_id", "i.team_id", "COALESCE(t.name, u.username, '')").
+			From("match_invites i").
+			LeftJoin("users u ON u.id = i.user_id").
+			LeftJoin("teams t ON t.id = i.team_id").
+			Where(sq.Eq{"i.match_id": matchID}).
+			OrderBy("i.id ASC").
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		type inviteRow struct {
+			ID     int    `json:"id"`
+			UserID *int   `json:"user_id,omitempty"`
+			TeamID *int   `json:"team_id,omitempty"`
+			Name   string `json:"name"`
+		}
+
+		out := []inviteRow{}
+		for rows.Next() {
+			var r inviteRow
+			if err := rows.Scan(&r.ID, &r.UserID, &r.TeamID, &r.Name); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			out = append(out, r)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// POST /api/admin/matches/:id/invites  { "user_id": 1 } | { "team_id": 2 }
+func AdminAddMatchInvite(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		var req struct {
+			UserID *int `json:"user_id"`
+			TeamID *int `json:"team_id"`
+		}
+		if err := c.BindJSON(&req); err != nil || (req.UserID == nil) == (req.TeamID == nil) {
+			jsonErr(c, 400, "Укажите игрока или команду")
+			return
+		}
+
+		ctx := context.Background()
+
+		var mStatus string
+		qM := sq.Select("status").From("matches").Where(sq.Eq{"id": matchID}).PlaceholderFormat(sq.Dollar)
+		if err := qRow(ctx, db, qM).Scan(&mStatus); err != nil {
+			jsonErr(c, 404, "Матч не найден")
+			return
+		}
+		if mStatus == "finished" {
+			jsonErr(c, 400, "Матч уже завершён")
+			return
+		}
+
+		ins := sq.Insert("match_invites").
+			Columns("match_id", "user_id", "team_id", "invited_by").
+			Values(matchID, req.UserID, req.TeamID, actor).
+			Suffix("ON CONFLICT DO NOTHING").
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExec(ctx, db, ins); err != nil {
+			jsonErr(c, 400, "Игрок или команда не найдены")
+			return
+		}
+
+		logAction(db, &actor, "admin_match_invite", "Администратор пригласил на матч")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// DELETE /api/admin/matches/:id/invites/:iid
+func AdminDeleteMatchInvite(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		inviteID, _ := strconv.Atoi(c.Param("iid"))
+		if matchID <= 0 || inviteID <= 0 {
+			jsonErr(c, 400, "Некорректное приглашение")
+			return
+		}
+
+		ctx := context.Background()
+
+		del := sq.Delete("match_invites").
+			Where(sq.Eq{"id": inviteID, "match_id": matchID}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExec(ctx, db, del)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 404, "Приглашение не найдено")
+			return
+		}
+
+		logAction(db, &actor, "admin_match_uninvite", "Администратор отозвал приглашение на матч")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
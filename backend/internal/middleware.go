@@ -1,21 +1,29 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const cookieName = "ctf_token"
 
 type claims struct {
-	UserID int    `json:"uid"`
-	Role   string `json:"role"`
+	UserID    int   `json:"uid"`
+	SessionID int64 `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func Auth(secret string) gin.HandlerFunc {
+// Auth проверяет access-токен и сессию, на которую он выписан: отозванная
+// сессия, удалённый или заблокированный пользователь не проходят. Роль
+// берётся из кэша состояний (userStates), а не из токена, — понижение
+// вступает в силу сразу.
+func Auth(db *pgxpool.Pool, secret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenStr, err := c.Cookie(cookieName)
 		if err != nil || tokenStr == "" {
@@ -37,12 +45,31 @@ func Auth(secret string) gin.HandlerFunc {
 			return
 		}
 
+		st, err := loadUserState(context.Background(), db, cl.SessionID, cl.UserID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
+		}
+
+		if st.Banned {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "user banned"})
+			return
+		}
+
 		c.Set("uid", cl.UserID)
-		c.Set("role", cl.Role)
+		c.Set("sid", cl.SessionID)
+		c.Set("role", st.Role)
+		c.Set("mfa", st.MFA)
 		c.Next()
 	}
 }
 
+// RequireAdmin — после Auth: роль в контексте уже актуальная.
+// Для админских маршрутов дополнительно нужен RequireMFA.
 func RequireAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, _ := c.Get("role")
@@ -1,17 +1,38 @@
 package internal
 
+import "time"
+
 type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
-	Points   int    `json:"points"`
+	Points   int    `json:"points"` // очки достижений (сумма журнала points_transactions)
+	Rating   int    `json:"rating"` // Elo
+	Banned   bool   `json:"banned,omitempty"`
 }
 
 type Match struct {
-	ID     int    `json:"id"`
-	Title  string `json:"title"`
-	Mode   string `json:"mode"`   // solo|team
-	Status string `json:"status"` // open|closed|finished
+	ID       int        `json:"id"`
+	Title    string     `json:"title"`
+	Mode     string     `json:"mode"`   // solo|team
+	Status   string     `json:"status"` // open|closed|finished
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+
+	TournamentID *int `json:"tournament_id,omitempty"`
+
+	MatchRules
+}
+
+// MatchRules — условия участия в матче. Нули — «без ограничения»
+// (для размеров команды — 1..MaxTeamMembers).
+type MatchRules struct {
+	MinTeamSize     int  `json:"min_team_size"`
+	MaxTeamSize     int  `json:"max_team_size"`
+	MaxParticipants int  `json:"max_participants"` // заявок (игроков или команд); остальные — в лист ожидания
+	MinRating       int  `json:"min_rating"`       // Elo игрока или команды
+	InviteOnly      bool `json:"invite_only"`
+	AllowSolo       bool `json:"allow_solo"` // одиночки в командном матче
 }
 
 type Team struct {
@@ -20,10 +41,150 @@ type Team struct {
 	IsOpen bool   `json:"is_open"`
 }
 
+// TeamRating — строка рейтинга команд.
+type TeamRating struct {
+	Rank    int    `json:"rank"`
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Rating  int    `json:"rating"`
+	Wins    int    `json:"wins"`
+	Points  int    `json:"points"`
+	Played  int    `json:"played"`
+	Members int    `json:"members"`
+}
+
+// TeamInvite — приглашение в команду, как его видит приглашённый.
+type TeamInvite struct {
+	ID        int       `json:"id"`
+	TeamID    int       `json:"team_id"`
+	TeamName  string    `json:"team_name"`
+	InvitedBy string    `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// JoinRequest — заявка игрока в закрытую команду.
+type JoinRequest struct {
+	ID        int       `json:"id"`
+	TeamID    int       `json:"team_id"`
+	TeamName  string    `json:"team_name"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Status    string    `json:"status"` // pending|approved|rejected
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type Application struct {
-	ID      int   `json:"id"`
-	MatchID int   `json:"match_id"`
-	UserID  int   `json:"user_id"`
-	TeamID  *int  `json:"team_id,omitempty"`
-	Status  string `json:"status"`
+	ID      int    `json:"id"`
+	MatchID int    `json:"match_id"`
+	UserID  int    `json:"user_id"`
+	TeamID  *int   `json:"team_id,omitempty"`
+	Status  string `json:"status"` // pending|approved|rejected|waitlisted|withdrawn
+}
+
+type Challenge struct {
+	ID          int    `json:"id"`
+	MatchID     int    `json:"match_id"`
+	Name        string `json:"name"`
+	Category    string `json:"category"`
+	Description string `json:"description"`
+	Points      int    `json:"points"`  // начальная стоимость
+	Scoring     string `json:"scoring"` // static|dynamic
+	Minimum     int    `json:"minimum"`
+	Decay       int    `json:"decay"`
+	Value       int    `json:"value"` // текущая стоимость с учётом решений
+	Solves      int    `json:"solves"`
+	Solved      bool   `json:"solved"`
+	Flag        string `json:"flag,omitempty"` // только для админа
+}
+
+type ScoreEntry struct {
+	Rank      int        `json:"rank"`
+	UserID    *int       `json:"user_id,omitempty"`
+	TeamID    *int       `json:"team_id,omitempty"`
+	Name      string     `json:"name"`
+	Score     int        `json:"score"`
+	Solves    int        `json:"solves"`
+	LastSolve *time.Time `json:"last_solve,omitempty"`
+}
+
+type Tournament struct {
+	ID           int    `json:"id"`
+	Title        string `json:"title"`
+	Mode         string `json:"mode"`   // solo|team
+	Format       string `json:"format"` // single_elim|double_elim|round_robin|swiss
+	Status       string `json:"status"` // running|finished
+	WinnerUserID *int   `json:"winner_user_id,omitempty"`
+	WinnerTeamID *int   `json:"winner_team_id,omitempty"`
+}
+
+type Standing struct {
+	Rank     int     `json:"rank"`
+	UserID   *int    `json:"user_id,omitempty"`
+	TeamID   *int    `json:"team_id,omitempty"`
+	Name     string  `json:"name"`
+	Played   int     `json:"played"`
+	Wins     int     `json:"wins"`
+	Draws    int     `json:"draws"`
+	Losses   int     `json:"losses"`
+	Byes     int     `json:"byes"`
+	Points   float64 `json:"points"`
+	Buchholz float64 `json:"buchholz"`
+
+	seed      int
+	opponents []entrant
+}
+
+// PointsTransaction — запись журнала очков; users.points — их сумма.
+type PointsTransaction struct {
+	ID         int64     `json:"id"`
+	UserID     int       `json:"user_id"`
+	Delta      int       `json:"delta"`
+	Reason     string    `json:"reason"`
+	MatchID    *int      `json:"match_id,omitempty"`
+	MatchTitle string    `json:"match_title,omitempty"`
+	ActorID    *int      `json:"actor_id,omitempty"`
+	RevertedBy *int64    `json:"reverted_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Season struct {
+	ID        int        `json:"id"`
+	Title     string     `json:"title"`
+	Status    string     `json:"status"` // open|closed
+	Reset     string     `json:"reset"`  // zero|soft
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// SeasonStanding — строка таблицы сезона (живой или сохранённой при закрытии).
+type SeasonStanding struct {
+	Rank     int    `json:"rank"`
+	UserID   *int   `json:"user_id"`
+	Username string `json:"username"`
+	Points   int    `json:"points"`
+	Rating   int    `json:"rating"`
+	Played   int    `json:"played"`
+}
+
+// Session — активная сессия входа, как её видит владелец.
+type Session struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	MFA        bool      `json:"mfa"`
+	Current    bool      `json:"current"`
+}
+
+// LoginLockout — счётчик неудачных входов по логину или IP.
+type LoginLockout struct {
+	Kind         string     `json:"kind"` // user|ip
+	Key          string     `json:"key"`
+	Failures     int        `json:"failures"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until"`
+	Locked       bool       `json:"locked"`
 }
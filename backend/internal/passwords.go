@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newPasswordErr проверяет новый пароль; "" — подходит.
+func newPasswordErr(password, password2 string) string {
+	if password == "" || password2 == "" {
+		return "Заполните все поля"
+	}
+	if password != password2 {
+		return "Пароли не совпадают"
+	}
+	if len(password) < MinPassword {
+		return "Пароль слишком короткий"
+	}
+	if len(password) > MaxPassword {
+		return "Пароль слишком длинный"
+	}
+	return ""
+}
+
+// setPasswordTx меняет хэш пароля, отзывает все сессии пользователя и
+// незавершённые входы со вторым фактором, начатые со старым паролем.
+// После коммита нужен invalidateUser.
+func setPasswordTx(ctx context.Context, tx pgx.Tx, userID int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return err
+	}
+
+	upd := sq.Update("users").
+		Set("pass_hash", string(hash)).
+		Where(sq.Eq{"id": userID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, upd); err != nil {
+		return err
+	}
+
+	delChallenges := sq.Delete("login_challenges").
+		Where(sq.Eq{"user_id": userID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, delChallenges); err != nil {
+		return err
+	}
+	return revokeUserSessionsTx(ctx, tx, userID)
+}
+
+/* ===================== PASSWORD CHANGE ===================== */
+
+// POST /api/me/password  { "current": "...", "password": "...", "password2": "..." }
+// Все сессии, включая текущую, отзываются; этому клиенту сразу выдаётся новая.
+func ChangePassword(db *pgxpool.Pool, secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+
+		var req struct {
+			Current   string `json:"current"`
+			Password  string `json:"password"`
+			Password2 string `json:"password2"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		if msg := newPasswordErr(req.Password, req.Password2); msg != "" {
+			jsonErr(c, 400, msg)
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var passHash string
+		q := sq.Select("pass_hash").
+			From("users").
+			Where(sq.Eq{"id": userID}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&passHash); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passHash), []byte(req.Current)) != nil {
+			jsonErr(c, 400, "Неверный текущий пароль")
+			return
+		}
+		if req.Password == req.Current {
+			jsonErr(c, 400, "Новый пароль совпадает с текущим")
+			return
+		}
+
+		if err := setPasswordTx(ctx, tx, userID, req.Password); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		invalidateUser(userID)
+
+		mfa, _ := c.Get("mfa")
+		if err := startSession(c, db, secret, userID, mfa == true); err != nil {
+			clearAuthCookies(c)
+		}
+
+		logAction(db, &userID, "change_password", "Пользователь сменил пароль")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+/* ===================== PASSWORD RESET ===================== */
+
+// POST /api/admin/users/:id/reset-token
+// Одноразовый токен сброса пароля; администратор передаёт его пользователю.
+// Хранится только хэш, прежние неиспользованные токены гасятся.
+func AdminPasswordResetToken(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный пользователь")
+			return
+		}
+
+		token, err := randomToken()
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var username string
+		qU := sq.Select("username").
+			From("users").
+			Where(sq.Eq{"id": id}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qU).Scan(&username); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				jsonErr(c, 404, "Пользователь не найден")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		expire := sq.Update("password_resets").
+			Set("used_at", sq.Expr(sqlNowUTC)).
+			Where(sq.Eq{"user_id": id, "used_at": nil}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, expire); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		ins := sq.Insert("password_resets").
+			Columns("user_id", "token_hash", "created_by", "expires_at").
+			Values(id, hashToken(token), actor, sq.Expr(sqlNowUTC+" + make_interval(hours => ?)", PasswordResetTTLHours)).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, ins); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_reset_token", "Токен сброса пароля: "+clampRunes(username, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true, "token": token, "expires_in_hours": PasswordResetTTLHours})
+	}
+}
+
+// POST /api/auth/reset-password  { "token": "...", "password": "...", "password2": "..." }
+func ResetPassword(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token     string `json:"token"`
+			Password  string `json:"password"`
+			Password2 string `json:"password2"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		req.Token = strings.TrimSpace(req.Token)
+		if req.Token == "" {
+			jsonErr(c, 400, "Укажите токен сброса")
+			return
+		}
+		if msg := newPasswordErr(req.Password, req.Password2); msg != "" {
+			jsonErr(c, 400, msg)
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var resetID int64
+		var userID int
+		q := sq.Select("id", "user_id").
+			From("password_resets").
+			Where(sq.Eq{"token_hash": hashToken(req.Token), "used_at": nil}).
+			Where(sq.Expr("expires_at > " + sqlNowUTC)).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&resetID, &userID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				jsonErr(c, 400, "Токен недействителен или истёк")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		used := sq.Update("password_resets").
+			Set("used_at", sq.Expr(sqlNowUTC)).
+			Where(sq.Eq{"id": resetID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, used); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if err := setPasswordTx(ctx, tx, userID, req.Password); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		invalidateUser(userID)
+
+		logAction(db, &userID, "reset_password", "Пароль сброшен по токену")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Причины движения очков в журнале.
+const (
+	reasonMatchSolves = "match_solves" // очки за решённые задания
+	reasonMatchBonus  = "match_bonus"  // бонус победителю / очки за место
+	reasonAdminSet    = "admin_set"    // ручная правка администратором
+	reasonRevert      = "revert"       // отмена другой записи
+)
+
+/* ===================== POINTS LEDGER ===================== */
+
+// addPointsTx — единственный способ изменить очки: пишет запись в журнал
+// для каждого пользователя и обновляет кэш users.points.
+func addPointsTx(ctx context.Context, tx pgx.Tx, ids []int, delta int, reason string, matchID, actor *int) error {
+	if len(ids) == 0 || delta == 0 {
+		return nil
+	}
+
+	ins := sq.Insert("points_transactions").
+		Columns("user_id", "delta", "reason", "match_id", "actor_id").
+		Select(sq.Select("id").
+			Column(sq.Expr("?::int", delta)).
+			Column(sq.Expr("?::text", reason)).
+			Column(sq.Expr("?::int", matchID)).
+			Column(sq.Expr("?::int", actor)).
+			From("users").
+			Where(sq.Eq{"id": ids})).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, ins); err != nil {
+		return err
+	}
+
+	upd := sq.Update("users").
+		Set("points", sq.Expr("points + ?", delta)).
+		Where(sq.Eq{"id": ids}).
+		PlaceholderFormat(sq.Dollar)
+
+	_, err := qExecTx(ctx, tx, upd)
+	return err
+}
+
+// revertPointsTx отменяет запись журнала встречной записью.
+// false — запись уже отменена или сама является отменой.
+func revertPointsTx(ctx context.Context, tx pgx.Tx, txID int64, actor *int) (bool, error) {
+	var userID, delta int
+	var matchID *int
+	q := sq.Select("user_id", "delta", "match_id").
+		From("points_transactions").
+		Where(sq.Eq{"id": txID, "reverted_by": nil}).
+		Where(sq.NotEq{"reason": reasonRevert}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, q).Scan(&userID, &delta, &matchID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var revID int64
+	ins := sq.Insert("points_transactions").
+		Columns("user_id", "delta", "reason", "match_id", "actor_id").
+		Values(userID, -delta, reasonRevert, matchID, actor).
+		Suffix("RETURNING id").
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, ins).Scan(&revID); err != nil {
+		return false, err
+	}
+
+	updT := sq.Update("points_transactions").
+		Set("reverted_by", revID).
+		Where(sq.Eq{"id": txID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, updT); err != nil {
+		return false, err
+	}
+
+	updU := sq.Update("users").
+		Set("points", sq.Expr("points - ?", delta)).
+		Where(sq.Eq{"id": userID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, updU); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// loadLedger — последние записи журнала пользователя (новые сверху).
+func loadLedger(ctx context.Context, db *pgxpool.Pool, userID int) ([]PointsTransaction, error) {
+	q := sq.Select("p.id", "p.delta", "p.reason", "p.match_id", "COALESCE(m.title, '')", "p.actor_id", "p.reverted_by", "p.created_at").
+		From("points_transactions p").
+		LeftJoin("matches m ON m.id = p.match_id").
+		Where(sq.Eq{"p.user_id": userID}).
+		OrderBy("p.id DESC").
+		Limit(500).
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := qQuery(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []PointsTransaction{}
+	for rows.Next() {
+		var t PointsTransaction
+		if err := rows.Scan(&t.ID, &t.Delta, &t.Reason, &t.MatchID, &t.MatchTitle, &t.ActorID, &t.RevertedBy, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.UserID = userID
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+/* ===================== POINTS (USER) ===================== */
+
+// GET /api/my/points — журнал своих очков
+func MyPoints(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		ctx := context.Background()
+
+		var total int
+		q := sq.Select("points").From("users").Where(sq.Eq{"id": userID}).PlaceholderFormat(sq.Dollar)
+		if err := qRow(ctx, db, q).Scan(&total); err != nil {
+			jsonErr(c, 404, "Пользователь не найден")
+			return
+		}
+
+		ledger, err := loadLedger(ctx, db, userID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, gin.H{"points": total, "transactions": ledger})
+	}
+}
+
+/* ===================== ADMIN: POINTS ===================== */
+
+// GET /api/admin/users/:id/points
+func AdminUserPoints(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный пользователь")
+			return
+		}
+
+		ctx := context.Background()
+
+		var total int
+		q := sq.Select("points").From("users").Where(sq.Eq{"id": id}).PlaceholderFormat(sq.Dollar)
+		if err := qRow(ctx, db, q).Scan(&total); err != nil {
+			jsonErr(c, 404, "Пользователь не найден")
+			return
+		}
+
+		ledger, err := loadLedger(ctx, db, id)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, gin.H{"points": total, "transactions": ledger})
+	}
+}
+
+// POST /api/admin/points/:tid/revert
+func AdminRevertPoints(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		txID, _ := strconv.ParseInt(c.Param("tid"), 10, 64)
+		if txID <= 0 {
+			jsonErr(c, 400, "Некорректная запись")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		ok, err := revertPointsTx(ctx, tx, txID, &actor)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if !ok {
+			jsonErr(c, 400, "Запись не найдена или уже отменена")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_revert_points", "Администратор отменил начисление #"+strconv.FormatInt(txID, 10))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var errMatchFinished = errors.New("match already finished")
+
+/* ===================== MATCH RESULT ===================== */
+
+// finishMatchTx завершает матч: фиксирует победителя, начисляет каждому
+// участнику очки за задания по таблице и дополнительные очки из extra
+// (бонус победителю, очки за место), пересчитывает Elo игроков и команд. Все начисления
+// идут в журнал с match_id — по нему результат можно отменить.
+// actor — администратор; nil для планировщика.
+// Повторное завершение — errMatchFinished.
+func finishMatchTx(ctx context.Context, tx pgx.Tx, actor *int, matchID int, winner entrant, extra map[entrant]int) error {
+	updM := sq.Update("matches").
+		Set("status", "finished").
+		Set("winner_user_id", winner.userPtr()).
+		Set("winner_team_id", winner.teamPtr()).
+		Where(sq.Eq{"id": matchID}).
+		Where(sq.NotEq{"status": "finished"}).
+		PlaceholderFormat(sq.Dollar)
+
+	tag, err := qExecTx(ctx, tx, updM)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errMatchFinished
+	}
+
+	board, err := loadScoreboardTx(ctx, tx, matchID)
+	if err != nil {
+		return err
+	}
+
+	earned := map[entrant]int{}
+	award := func(e entrant, pts int, reason string) error {
+		earned[e] += pts
+		ids, err := entrantMembersTx(ctx, tx, matchID, e)
+		if err != nil {
+			return err
+		}
+		return addPointsTx(ctx, tx, ids, pts, reason, &matchID, actor)
+	}
+	for _, se := range board {
+		if se.Score > 0 {
+			if err := award(se.entrant(), se.Score, reasonMatchSolves); err != nil {
+				return err
+			}
+		}
+	}
+	for e, pts := range extra {
+		if err := award(e, pts, reasonMatchBonus); err != nil {
+			return err
+		}
+	}
+
+	if err := applyRatingTx(ctx, tx, matchID, winner); err != nil {
+		return err
+	}
+	if err := recordTeamResultsTx(ctx, tx, matchID, winner, earned); err != nil {
+		return err
+	}
+
+	// турнирный матч: победитель проходит дальше по сетке
+	return advanceTournamentTx(ctx, tx, matchID, winner)
+}
+
+// revertMatchResultTx отменяет всё, что начислило завершение матча:
+// записи журнала очков с этим match_id, изменения Elo и итоги команд.
+func revertMatchResultTx(ctx context.Context, tx pgx.Tx, actor *int, matchID int) error {
+	q := sq.Select("id").
+		From("points_transactions").
+		Where(sq.Eq{"match_id": matchID, "reverted_by": nil}).
+		Where(sq.NotEq{"reason": reasonRevert}).
+		OrderBy("id").
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := qQueryTx(ctx, tx, q)
+	if err != nil {
+		return err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := revertPointsTx(ctx, tx, id, actor); err != nil {
+			return err
+		}
+	}
+
+	// Elo: возвращаем разницу и убираем записи — при повторном
+	// завершении рейтинг посчитается заново
+	updR := sq.Update("users u").
+		Set("rating", sq.Expr("u.rating - d.delta")).
+		Suffix("FROM (SELECT user_id, SUM(new_rating - old_rating) AS delta FROM rating_changes WHERE match_id = ? GROUP BY user_id) d WHERE d.user_id = u.id", matchID).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, updR); err != nil {
+		return err
+	}
+
+	delR := sq.Delete("rating_changes").Where(sq.Eq{"match_id": matchID}).PlaceholderFormat(sq.Dollar)
+	if _, err := qExecTx(ctx, tx, delR); err != nil {
+		return err
+	}
+
+	return revertTeamResultsTx(ctx, tx, matchID)
+}
+
+// scoreboardWinner — первое место таблицы; пустой entrant, если решений не было.
+func scoreboardWinner(board []ScoreEntry) entrant {
+	if len(board) == 0 || board[0].Score <= 0 {
+		return entrant{}
+	}
+	return board[0].entrant()
+}
+
+// placementAwards раздаёт очки за места по таблице (только тем, у кого есть решения).
+func placementAwards(board []ScoreEntry, placement []int) map[entrant]int {
+	extra := map[entrant]int{}
+	for i, se := range board {
+		if i >= len(placement) || se.Score <= 0 {
+			break
+		}
+		extra[se.entrant()] += placement[i]
+	}
+	return extra
+}
+
+/* ===================== ADMIN: FINALIZE ===================== */
+
+// POST /api/admin/matches/:id/finalize  { "placement_points": [100, 50, 25] }
+// Победитель и места определяются по таблице решений; при равенстве очков
+// выше тот, кто раньше сделал последнее решение. Ручной выбор победителя
+// по-прежнему доступен через /winner.
+func AdminFinalizeMatch(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		var req struct {
+			PlacementPoints []int `json:"placement_points"`
+		}
+		// тело необязательно, но битый JSON — ошибка
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		placement := req.PlacementPoints
+		if placement == nil {
+			placement = DefaultPlacementPoints
+		}
+		if len(placement) > MaxPlacements {
+			jsonErr(c, 400, "Слишком много призовых мест")
+			return
+		}
+		for _, p := range placement {
+			if p < 0 || p > 1_000_000 {
+				jsonErr(c, 400, "Некорректные очки")
+				return
+			}
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var mStatus, mTitle string
+		qM := sq.Select("status", "title").
+			From("matches").
+			Where(sq.Eq{"id": matchID}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qM).Scan(&mStatus, &mTitle); err != nil {
+			jsonErr(c, 404, "Матч не найден")
+			return
+		}
+		if mStatus == "finished" {
+			jsonErr(c, 400, "Матч уже завершён")
+			return
+		}
+
+		board, err := loadScoreboardTx(ctx, tx, matchID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		winner := scoreboardWinner(board)
+		if winner == (entrant{}) {
+			jsonErr(c, 400, "Нет решений — выберите победителя вручную")
+			return
+		}
+
+		if err := finishMatchTx(ctx, tx, &actor, matchID, winner, placementAwards(board, placement)); err != nil {
+			if errors.Is(err, errMatchFinished) {
+				jsonErr(c, 400, "Матч уже завершён")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_finalize_match", "Администратор подвёл итоги матча: "+clampRunes(mTitle, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true, "ranking": board})
+	}
+}
+
+/* ===================== ADMIN: REOPEN ===================== */
+
+// POST /api/admin/matches/:id/reopen
+// Отменяет итоги завершённого матча: очки и Elo возвращаются, победитель
+// сбрасывается, матч снова идёт (closed) — итоги можно подвести заново.
+// Прошедший ends_at снимается, чтобы планировщик не завершил матч сразу.
+func AdminReopenMatch(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		matchID, _ := strconv.Atoi(c.Param("id"))
+		if matchID <= 0 {
+			jsonErr(c, 400, "Некорректный матч")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var mStatus, mTitle string
+		qM := sq.Select("status", "title").
+			From("matches").
+			Where(sq.Eq{"id": matchID}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qM).Scan(&mStatus, &mTitle); err != nil {
+			jsonErr(c, 404, "Матч не найден")
+			return
+		}
+		if mStatus != "finished" {
+			jsonErr(c, 400, "Матч ещё не завершён")
+			return
+		}
+
+		if err := undoAdvanceTx(ctx, tx, matchID); err != nil {
+			if errors.Is(err, errTournamentAdvanced) {
+				jsonErr(c, 400, "Следующие матчи турнира уже начались")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := revertMatchResultTx(ctx, tx, &actor, matchID); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		upd := sq.Update("matches").
+			Set("status", "closed").
+			Set("winner_user_id", nil).
+			Set("winner_team_id", nil).
+			Set("ends_at", sq.Expr("CASE WHEN ends_at <= "+sqlNowUTC+" THEN NULL ELSE ends_at END")).
+			Where(sq.Eq{"id": matchID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_reopen_match", "Администратор отменил итоги матча: "+clampRunes(mTitle, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
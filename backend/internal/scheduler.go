@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Колонки starts_at/ends_at — TIMESTAMP без зоны, храним в них UTC.
+const sqlNowUTC = "(now() AT TIME ZONE 'UTC')"
+
+const schedulerInterval = 15 * time.Second
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func validSchedule(startsAt, endsAt *time.Time) bool {
+	return startsAt == nil || endsAt == nil || endsAt.After(*startsAt)
+}
+
+/* ===================== SCHEDULER ===================== */
+
+// RunScheduler переводит матчи по расписанию: open -> closed в starts_at
+// (регистрация закрыта, матч идёт) и closed -> finished в ends_at (итоги
+// подводятся по таблице решений). Всё состояние хранится в БД, переходы
+// выполняются условным UPDATE, поэтому после рестарта просроченные матчи
+// просто догоняются, а повторной обработки не бывает.
+func RunScheduler(db *pgxpool.Pool) {
+	t := time.NewTicker(schedulerInterval)
+	defer t.Stop()
+
+	for {
+		ctx := context.Background()
+		if err := closeDueMatches(ctx, db); err != nil {
+			log.Printf("scheduler: close matches: %v", err)
+		}
+		if err := finishDueMatches(ctx, db); err != nil {
+			log.Printf("scheduler: finish matches: %v", err)
+		}
+		<-t.C
+	}
+}
+
+func closeDueMatches(ctx context.Context, db *pgxpool.Pool) error {
+	// матч без starts_at закрывается к ends_at, чтобы его можно было завершить
+	upd := sq.Update("matches").
+		Set("status", "closed").
+		Where(sq.Eq{"status": "open"}).
+		Where(sq.Expr("COALESCE(starts_at, ends_at) <= " + sqlNowUTC)).
+		Suffix("RETURNING id, title").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := toSQL(upd)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	titles := []string{}
+	for rows.Next() {
+		var id int
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return err
+		}
+		titles = append(titles, title)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, title := range titles {
+		logAction(db, nil, "schedule_close_match", "Регистрация закрыта по расписанию: "+clampRunes(title, MaxReportLine))
+	}
+	return nil
+}
+
+func finishDueMatches(ctx context.Context, db *pgxpool.Pool) error {
+	q := sq.Select("id").
+		From("matches").
+		Where(sq.Eq{"status": "closed"}).
+		Where(sq.Expr("ends_at <= " + sqlNowUTC)).
+		OrderBy("ends_at ASC").
+		Limit(50).
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := qQuery(ctx, db, q)
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := finishScheduledMatch(ctx, db, id); err != nil {
+			log.Printf("scheduler: finish match %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func finishScheduledMatch(ctx context.Context, db *pgxpool.Pool, matchID int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// SKIP LOCKED: матч уже обрабатывает другой экземпляр или администратор
+	var title string
+	qM := sq.Select("title").
+		From("matches").
+		Where(sq.Eq{"id": matchID, "status": "closed"}).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, qM).Scan(&title); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	board, err := loadScoreboardTx(ctx, tx, matchID)
+	if err != nil {
+		return err
+	}
+	if err := finishMatchTx(ctx, tx, nil, matchID, scoreboardWinner(board), placementAwards(board, DefaultPlacementPoints)); err != nil {
+		if errors.Is(err, errMatchFinished) {
+			return nil
+		}
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	logAction(db, nil, "schedule_finish_match", "Матч завершён по расписанию: "+clampRunes(title, MaxReportLine))
+	return nil
+}
@@ -0,0 +1,397 @@
+package internal
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// currentSeasonSQL — id идущего сезона (NULL, если сезон не открыт);
+// новые матчи привязываются к нему при создании. FOR SHARE ждёт
+// параллельного закрытия сезона, чтобы матч не попал в уже закрытый.
+const currentSeasonSQL = "(SELECT id FROM seasons WHERE status = 'open' FOR SHARE)"
+
+const reasonSeasonReset = "season_reset"
+
+func normReset(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "zero":
+		return "zero"
+	case "soft":
+		return "soft"
+	default:
+		return "invalid"
+	}
+}
+
+/* ===================== SEASON STANDINGS ===================== */
+
+// seasonStandingsQuery — живая таблица сезона: очки и рейтинг игроков
+// и число сыгранных в сезоне матчей. Без PlaceholderFormat — запрос
+// используется и отдельно, и внутри INSERT ... SELECT.
+func seasonStandingsQuery(seasonID int) sq.SelectBuilder {
+	played := "(SELECT COUNT(*) FROM match_participants mp JOIN matches m ON m.id = mp.match_id" +
+		" WHERE mp.user_id = u.id AND m.season_id = ? AND m.status = 'finished') AS played"
+
+	return sq.Select().
+		Column("ROW_NUMBER() OVER (ORDER BY u.rating DESC, u.points DESC, u.id ASC) AS rank").
+		Columns("u.id", "u.username", "u.points", "u.rating").
+		Column(sq.Expr(played, seasonID)).
+		From("users u").
+		Where(sq.NotEq{"u.role": "admin"})
+}
+
+func scanSeasonStandings(rows pgx.Rows) ([]SeasonStanding, error) {
+	defer rows.Close()
+
+	out := []SeasonStanding{}
+	for rows.Next() {
+		var st SeasonStanding
+		if err := rows.Scan(&st.Rank, &st.UserID, &st.Username, &st.Points, &st.Rating, &st.Played); err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
+// seasonStandings — таблица сезона: для идущего считается на лету,
+// для закрытого берётся из снимка season_standings.
+func seasonStandings(ctx context.Context, db *pgxpool.Pool, s Season, limit, offset uint64) ([]SeasonStanding, error) {
+	var q sq.SelectBuilder
+	if s.Status == "open" {
+		q = sq.Select("*").
+			FromSelect(seasonStandingsQuery(s.ID), "st").
+			OrderBy("rank").
+			Limit(limit).
+			Offset(offset).
+			PlaceholderFormat(sq.Dollar)
+	} else {
+		q = sq.Select("rank", "user_id", "username", "points", "rating", "played").
+			From("season_standings").
+			Where(sq.Eq{"season_id": s.ID}).
+			OrderBy("rank").
+			Limit(limit).
+			Offset(offset).
+			PlaceholderFormat(sq.Dollar)
+	}
+
+	rows, err := qQuery(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+	return scanSeasonStandings(rows)
+}
+
+// applySeasonResetTx сбрасывает очки и рейтинг к началу сезона через журнал
+// очков и rating_changes: zero — очки в 0 и начальный рейтинг,
+// soft — половина очков и половина отрыва рейтинга от начального.
+// Администраторов сброс не касается: в рейтинге они не участвуют.
+func applySeasonResetTx(ctx context.Context, tx pgx.Tx, reset string, actor int) error {
+	initial := strconv.Itoa(InitialRating)
+	points, rating := "0", initial
+	if reset == "soft" {
+		points = "points / 2"
+		rating = initial + " + (rating - " + initial + ") / 2"
+	}
+
+	insP := sq.Insert("points_transactions").
+		Columns("user_id", "delta", "reason", "actor_id").
+		Select(sq.Select("id").
+			Column(points + " - points").
+			Column(sq.Expr("?::text", reasonSeasonReset)).
+			Column(sq.Expr("?::int", actor)).
+			From("users").
+			Where(sq.NotEq{"role": "admin"}).
+			Where("points <> " + points)).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, insP); err != nil {
+		return err
+	}
+
+	updP := sq.Update("users").
+		Set("points", sq.Expr(points)).
+		Where(sq.NotEq{"role": "admin"}).
+		Where("points <> " + points).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, updP); err != nil {
+		return err
+	}
+
+	insR := sq.Insert("rating_changes").
+		Columns("user_id", "old_rating", "new_rating").
+		Select(sq.Select("id", "rating").
+			Column(rating).
+			From("users").
+			Where(sq.NotEq{"role": "admin"}).
+			Where("rating <> " + rating)).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, insR); err != nil {
+		return err
+	}
+
+	updR := sq.Update("users").
+		Set("rating", sq.Expr(rating)).
+		Where(sq.NotEq{"role": "admin"}).
+		Where("rating <> " + rating).
+		PlaceholderFormat(sq.Dollar)
+
+	_, err := qExecTx(ctx, tx, updR)
+	return err
+}
+
+func loadSeason(ctx context.Context, db *pgxpool.Pool, where sq.Sqlizer) (Season, error) {
+	var s Season
+	q := sq.Select("id", "title", "status", "reset", "started_at", "ended_at").
+		From("seasons").
+		Where(where).
+		PlaceholderFormat(sq.Dollar)
+
+	err := qRow(ctx, db, q).Scan(&s.ID, &s.Title, &s.Status, &s.Reset, &s.StartedAt, &s.EndedAt)
+	return s, err
+}
+
+/* ===================== SEASONS (USER) ===================== */
+
+// GET /api/seasons
+func ListSeasons(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		q := sq.Select("id", "title", "status", "reset", "started_at", "ended_at").
+			From("seasons").
+			OrderBy("id DESC").
+			Limit(200).
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []Season{}
+		for rows.Next() {
+			var s Season
+			if err := rows.Scan(&s.ID, &s.Title, &s.Status, &s.Reset, &s.StartedAt, &s.EndedAt); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			out = append(out, s)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// GET /api/seasons/current?limit=&offset= — живая таблица идущего сезона
+func CurrentSeason(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, offset := pageParams(c)
+		ctx := context.Background()
+
+		s, err := loadSeason(ctx, db, sq.Eq{"status": "open"})
+		if err != nil {
+			jsonErr(c, 404, "Сезон не идёт")
+			return
+		}
+
+		standings, err := seasonStandings(ctx, db, s, limit, offset)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, gin.H{"season": s, "standings": standings})
+	}
+}
+
+// GET /api/seasons/:id/standings?limit=&offset=
+func SeasonStandings(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный сезон")
+			return
+		}
+		limit, offset := pageParams(c)
+		ctx := context.Background()
+
+		s, err := loadSeason(ctx, db, sq.Eq{"id": id})
+		if err != nil {
+			jsonErr(c, 404, "Сезон не найден")
+			return
+		}
+
+		standings, err := seasonStandings(ctx, db, s, limit, offset)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, gin.H{"season": s, "standings": standings})
+	}
+}
+
+/* ===================== ADMIN: SEASONS ===================== */
+
+// POST /api/admin/seasons { "title": "...", "reset": "zero|soft" }
+// Открывает сезон и сразу применяет сброс очков и рейтинга.
+func AdminOpenSeason(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+
+		var req struct {
+			Title string `json:"title"`
+			Reset string `json:"reset"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		req.Title = clampRunes(strings.TrimSpace(req.Title), MaxSeasonTitle)
+		req.Reset = normReset(req.Reset)
+		if req.Title == "" || req.Reset == "invalid" {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		open := sq.Select("1").From("seasons").Where(sq.Eq{"status": "open"})
+		qOpen := sq.Select().Column(sq.Expr("EXISTS(?)", open)).PlaceholderFormat(sq.Dollar)
+
+		var exists bool
+		if err := qRowTx(ctx, tx, qOpen).Scan(&exists); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if exists {
+			jsonErr(c, 400, "Сначала закройте текущий сезон")
+			return
+		}
+
+		var id int
+		ins := sq.Insert("seasons").
+			Columns("title", "status", "reset", "created_by").
+			Values(req.Title, "open", req.Reset, actor).
+			Suffix("RETURNING id").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, ins).Scan(&id); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := applySeasonResetTx(ctx, tx, req.Reset, actor); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_open_season", "Администратор открыл сезон: "+clampRunes(req.Title, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true, "id": id})
+	}
+}
+
+// POST /api/admin/seasons/:id/close — сохраняет итоговую таблицу сезона.
+// Закрыть можно, только когда все матчи сезона завершены.
+func AdminCloseSeason(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный сезон")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var status, title string
+		qS := sq.Select("status", "title").
+			From("seasons").
+			Where(sq.Eq{"id": id}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qS).Scan(&status, &title); err != nil {
+			jsonErr(c, 404, "Сезон не найден")
+			return
+		}
+		if status != "open" {
+			jsonErr(c, 400, "Сезон уже закрыт")
+			return
+		}
+
+		// итоги матча, завершённого после закрытия, попали бы в следующий сезон
+		unfinished := sq.Select("1").
+			From("matches").
+			Where(sq.Eq{"season_id": id}).
+			Where(sq.NotEq{"status": "finished"})
+		qUnfinished := sq.Select().
+			Column(sq.Expr("EXISTS(?)", unfinished)).
+			PlaceholderFormat(sq.Dollar)
+
+		var pending bool
+		if err := qRowTx(ctx, tx, qUnfinished).Scan(&pending); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if pending {
+			jsonErr(c, 400, "В сезоне есть незавершённые матчи")
+			return
+		}
+
+		snap := sq.Insert("season_standings").
+			Columns("rank", "user_id", "username", "points", "rating", "played", "season_id").
+			Select(seasonStandingsQuery(id).Column(sq.Expr("?::int", id))).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, snap); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		upd := sq.Update("seasons").
+			Set("status", "closed").
+			Set("ended_at", sq.Expr("now()")).
+			Where(sq.Eq{"id": id}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_close_season", "Администратор закрыл сезон: "+clampRunes(title, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
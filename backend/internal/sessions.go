@@ -0,0 +1,330 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Access-токен живёт недолго, сессия за ним проверяется в Auth на каждый
+// запрос; refresh-токен хранится только хэшем и меняется при каждом
+// обновлении. Повторное предъявление старого refresh-токена значит, что
+// его украли, — такая сессия отзывается целиком.
+const (
+	refreshCookieName = "ctf_refresh"
+	refreshCookiePath = "/api/auth"
+
+	accessTokenTTL  = AccessTokenMinutes * time.Minute
+	refreshTokenTTL = RefreshTokenDays * 24 * time.Hour
+)
+
+// sqlSessionActive — сессия не отозвана и не истекла.
+const sqlSessionActive = "s.revoked_at IS NULL AND s.expires_at > " + sqlNowUTC
+
+/* ===================== TOKENS ===================== */
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+func signAccessToken(secret string, userID int, sid int64) (string, error) {
+	now := time.Now()
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		UserID:    userID,
+		SessionID: sid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "ctf-platform",
+		},
+	})
+	return tok.SignedString([]byte(secret))
+}
+
+func setAuthCookies(c *gin.Context, access, refresh string) {
+	secure := os.Getenv("COOKIE_SECURE") == "1"
+	c.SetCookie(cookieName, access, int(accessTokenTTL/time.Second), "/", "", secure, true)
+	c.SetCookie(refreshCookieName, refresh, int(refreshTokenTTL/time.Second), refreshCookiePath, "", secure, true)
+}
+
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie(cookieName, "", -1, "/", "", false, true)
+	c.SetCookie(refreshCookieName, "", -1, refreshCookiePath, "", false, true)
+}
+
+/* ===================== SESSIONS ===================== */
+
+// startSession заводит сессию после успешного входа и ставит оба cookie.
+// mfa — вход подтверждён вторым фактором.
+func startSession(c *gin.Context, db *pgxpool.Pool, secret string, userID int, mfa bool) error {
+	ctx := context.Background()
+
+	refresh, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	ins := sq.Insert("sessions").
+		Columns("user_id", "refresh_hash", "expires_at", "user_agent", "ip", "mfa").
+		Values(userID, hashToken(refresh),
+			sq.Expr(sqlNowUTC+" + make_interval(days => ?)", RefreshTokenDays),
+			clampRunes(c.Request.UserAgent(), MaxUserAgent), c.ClientIP(), mfa).
+		Suffix("RETURNING id").
+		PlaceholderFormat(sq.Dollar)
+
+	var sid int64
+	if err := qRow(ctx, db, ins).Scan(&sid); err != nil {
+		return err
+	}
+
+	access, err := signAccessToken(secret, userID, sid)
+	if err != nil {
+		return err
+	}
+	setAuthCookies(c, access, refresh)
+	return nil
+}
+
+// revokeSessions отзывает активные сессии пользователя; only — одну из них.
+func revokeSessions(ctx context.Context, db *pgxpool.Pool, userID int, only *int64) (int64, error) {
+	upd := sq.Update("sessions").
+		Set("revoked_at", sq.Expr(sqlNowUTC)).
+		Where(sq.Eq{"user_id": userID, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar)
+
+	if only != nil {
+		upd = upd.Where(sq.Eq{"id": *only})
+	}
+
+	tag, err := qExec(ctx, db, upd)
+	if err != nil {
+		return 0, err
+	}
+	if only != nil {
+		invalidateSession(*only)
+	} else {
+		invalidateUser(userID)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// revokeUserSessionsTx — то же внутри транзакции (смена пароля, бан);
+// после коммита нужен invalidateUser.
+func revokeUserSessionsTx(ctx context.Context, tx pgx.Tx, userID int) error {
+	upd := sq.Update("sessions").
+		Set("revoked_at", sq.Expr(sqlNowUTC)).
+		Where(sq.Eq{"user_id": userID, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar)
+
+	_, err := qExecTx(ctx, tx, upd)
+	return err
+}
+
+func currentSession(c *gin.Context) int64 {
+	v, _ := c.Get("sid")
+	sid, _ := v.(int64)
+	return sid
+}
+
+/* ===================== HANDLERS ===================== */
+
+// POST /api/auth/refresh
+// Меняет refresh-токен из cookie на новую пару токенов.
+func Refresh(db *pgxpool.Pool, secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		refresh, err := c.Cookie(refreshCookieName)
+		if err != nil || refresh == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+			return
+		}
+		hash := hashToken(refresh)
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var sid int64
+		var userID int
+		var active, reused bool
+		q := sq.Select("s.id", "s.user_id").
+			Column(sq.Expr(sqlSessionActive)).
+			Column(sq.Expr("s.prev_hash = ?", hash)).
+			From("sessions s").
+			Where(sq.Or{sq.Eq{"s.refresh_hash": hash}, sq.Eq{"s.prev_hash": hash}}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&sid, &userID, &active, &reused); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				clearAuthCookies(c)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "bad token"})
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if reused && active {
+			revoke := sq.Update("sessions").
+				Set("revoked_at", sq.Expr(sqlNowUTC)).
+				Where(sq.Eq{"id": sid}).
+				PlaceholderFormat(sq.Dollar)
+
+			if _, err := qExecTx(ctx, tx, revoke); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			if err := tx.Commit(ctx); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			invalidateSession(sid)
+			logAction(db, &userID, "session_reuse", "Повторный refresh-токен, сессия отозвана")
+		}
+		if reused || !active {
+			clearAuthCookies(c)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			return
+		}
+
+		next, err := randomToken()
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		upd := sq.Update("sessions").
+			Set("prev_hash", hash).
+			Set("refresh_hash", hashToken(next)).
+			Set("last_used_at", sq.Expr(sqlNowUTC)).
+			Set("expires_at", sq.Expr(sqlNowUTC+" + make_interval(days => ?)", RefreshTokenDays)).
+			Where(sq.Eq{"id": sid}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		access, err := signAccessToken(secret, userID, sid)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		setAuthCookies(c, access, next)
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// GET /api/my/sessions
+func MySessions(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		current := currentSession(c)
+		ctx := context.Background()
+
+		q := sq.Select("s.id", "s.created_at", "s.last_used_at", "s.expires_at", "s.user_agent", "s.ip", "s.mfa").
+			From("sessions s").
+			Where(sq.Eq{"s.user_id": userID}).
+			Where(sqlSessionActive).
+			OrderBy("s.last_used_at DESC").
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []Session{}
+		for rows.Next() {
+			var s Session
+			if err := rows.Scan(&s.ID, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.UserAgent, &s.IP, &s.MFA); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			s.Current = s.ID == current
+			out = append(out, s)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// DELETE /api/my/sessions/:sid
+func RevokeSession(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		sid, _ := strconv.ParseInt(c.Param("sid"), 10, 64)
+		if sid <= 0 {
+			jsonErr(c, 400, "Некорректная сессия")
+			return
+		}
+
+		ctx := context.Background()
+		n, err := revokeSessions(ctx, db, userID, &sid)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if n == 0 {
+			jsonErr(c, 404, "Сессия не найдена")
+			return
+		}
+		if sid == currentSession(c) {
+			clearAuthCookies(c)
+		}
+
+		logAction(db, &userID, "revoke_session", "Пользователь завершил сессию")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// POST /api/auth/logout-all
+func LogoutAll(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		ctx := context.Background()
+
+		n, err := revokeSessions(ctx, db, userID, nil)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		clearAuthCookies(c)
+
+		logAction(db, &userID, "logout_all", "Выход на всех устройствах: "+strconv.FormatInt(n, 10))
+		c.JSON(200, gin.H{"ok": true, "revoked": n})
+	}
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/* ===================== TEAM RESULTS ===================== */
+
+// recordTeamResultsTx сохраняет итоги матча для каждой команды-участника:
+// заработанные очки, победу и изменение рейтинга команды. Рейтинг хранится
+// у самой команды и не зависит от смены состава.
+func recordTeamResultsTx(ctx context.Context, tx pgx.Tx, matchID int, winner entrant, earned map[entrant]int) error {
+	entrants, places, err := matchPlacesTx(ctx, tx, matchID, winner)
+	if err != nil {
+		return err
+	}
+
+	teams := []int{}
+	ratings := []float64{}
+	kept := []int{}
+	for i, e := range entrants {
+		if e.TeamID == 0 {
+			continue
+		}
+		var rating int
+		q := sq.Select("rating").
+			From("teams").
+			Where(sq.Eq{"id": e.TeamID}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&rating); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue // команду успели удалить
+			}
+			return err
+		}
+		teams = append(teams, e.TeamID)
+		ratings = append(ratings, float64(rating))
+		kept = append(kept, places[i])
+	}
+	if len(teams) == 0 {
+		return nil
+	}
+
+	deltas := make([]int, len(teams))
+	if len(kept) > 1 && kept[0] != kept[len(kept)-1] {
+		deltas = eloDeltas(ratings, kept, EloK)
+	}
+
+	for i, teamID := range teams {
+		e := entrant{TeamID: teamID}
+		won := e == winner
+		pts := earned[e]
+
+		ins := sq.Insert("team_results").
+			Columns("match_id", "team_id", "place", "points", "won", "rating_delta").
+			Values(matchID, teamID, kept[i], pts, won, deltas[i]).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, ins); err != nil {
+			return err
+		}
+
+		upd := sq.Update("teams").
+			Set("rating", sq.Expr("rating + ?", deltas[i])).
+			Set("points", sq.Expr("points + ?", pts)).
+			Where(sq.Eq{"id": teamID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if won {
+			upd = upd.Set("wins", sq.Expr("wins + 1"))
+		}
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertTeamResultsTx откатывает recordTeamResultsTx (повторное открытие матча).
+func revertTeamResultsTx(ctx context.Context, tx pgx.Tx, matchID int) error {
+	upd := sq.Update("teams t").
+		Set("rating", sq.Expr("t.rating - r.rating_delta")).
+		Set("points", sq.Expr("t.points - r.points")).
+		Set("wins", sq.Expr("t.wins - CASE WHEN r.won THEN 1 ELSE 0 END")).
+		Suffix("FROM team_results r WHERE r.team_id = t.id AND r.match_id = ?", matchID).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, upd); err != nil {
+		return err
+	}
+
+	del := sq.Delete("team_results").Where(sq.Eq{"match_id": matchID}).PlaceholderFormat(sq.Dollar)
+	_, err := qExecTx(ctx, tx, del)
+	return err
+}
+
+/* ===================== TEAM RATING ===================== */
+
+// GET /api/rating/teams?q=&limit=&offset=
+func RatingTeams(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, offset := pageParams(c)
+		search := strings.TrimSpace(clampRunes(c.Query("q"), 32))
+
+		ctx := context.Background()
+
+		played := sq.Select("COUNT(*)").
+			From("team_results r").
+			Where(sq.Expr("r.team_id = t.id"))
+
+		members := sq.Select("COUNT(*)").
+			From("team_members tm").
+			Where(sq.Expr("tm.team_id = t.id"))
+
+		// место считаем по всем командам, а поиск только фильтрует строки
+		ranked := sq.Select("t.id", "t.name", "t.rating", "t.wins", "t.points").
+			Column(sq.Expr("(?) AS played", played)).
+			Column(sq.Expr("(?) AS members", members)).
+			Column("ROW_NUMBER() OVER (ORDER BY t.rating DESC, t.wins DESC, t.points DESC, t.id ASC) AS rank").
+			From("teams t").
+			Where(sq.Eq{"t.disbanded_at": nil})
+
+		q := sq.Select("rank", "id", "name", "rating", "wins", "points", "played", "members").
+			FromSelect(ranked, "r").
+			OrderBy("rank").
+			Limit(limit).
+			Offset(offset).
+			PlaceholderFormat(sq.Dollar)
+
+		if search != "" {
+			q = q.Where(sq.Expr("name ILIKE ?", "%"+search+"%"))
+		}
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []TeamRating{}
+		for rows.Next() {
+			var t TeamRating
+			if err := rows.Scan(&t.Rank, &t.ID, &t.Name, &t.Rating, &t.Wins, &t.Points, &t.Played, &t.Members); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			out = append(out, t)
+		}
+		c.JSON(200, out)
+	}
+}
@@ -0,0 +1,995 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	errTeamNotFound = errors.New("team not found")
+	errHasTeam      = errors.New("user already in a team")
+	errTeamFull     = errors.New("team is full")
+)
+
+/* ===================== TEAM MEMBERSHIP ===================== */
+
+// addTeamMemberTx — единая точка вступления в команду (открытая команда,
+// приглашение, заявка). Строки команды и пользователя блокируются, так что
+// проверки «одна команда на пользователя» и MaxTeamMembers не обходятся
+// параллельными запросами.
+func addTeamMemberTx(ctx context.Context, tx pgx.Tx, teamID, userID int) error {
+	qTeam := sq.Select("id").
+		From("teams").
+		Where(sq.Eq{"id": teamID, "disbanded_at": nil}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	var id int
+	if err := qRowTx(ctx, tx, qTeam).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errTeamNotFound
+		}
+		return err
+	}
+
+	qUser := sq.Select("id").
+		From("users").
+		Where(sq.Eq{"id": userID}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, qUser).Scan(&id); err != nil {
+		return err
+	}
+
+	sub := sq.Select("1").From("team_members").Where(sq.Eq{"user_id": userID})
+	qHas := sq.Select().
+		Column(sq.Expr("EXISTS(?)", sub)).
+		PlaceholderFormat(sq.Dollar)
+
+	var has bool
+	if err := qRowTx(ctx, tx, qHas).Scan(&has); err != nil {
+		return err
+	}
+	if has {
+		return errHasTeam
+	}
+
+	qCount := sq.Select("COUNT(*)").
+		From("team_members").
+		Where(sq.Eq{"team_id": teamID}).
+		PlaceholderFormat(sq.Dollar)
+
+	var count int
+	if err := qRowTx(ctx, tx, qCount).Scan(&count); err != nil {
+		return err
+	}
+	if count >= MaxTeamMembers {
+		return errTeamFull
+	}
+
+	locked, err := teamRosterLockedTx(ctx, tx, teamID)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return errRosterLocked
+	}
+
+	ins := sq.Insert("team_members").
+		Columns("team_id", "user_id").
+		Values(teamID, userID).
+		PlaceholderFormat(sq.Dollar)
+
+	_, err = qExecTx(ctx, tx, ins)
+	return err
+}
+
+// teamMemberErr отвечает клиенту по ошибке addTeamMemberTx.
+// self — вступает сам пользователь (иначе его добавляет кто-то другой).
+func teamMemberErr(c *gin.Context, err error, self bool) {
+	switch {
+	case errors.Is(err, errTeamNotFound):
+		jsonErr(c, 404, "Команда не найдена")
+	case errors.Is(err, errHasTeam) && self:
+		jsonErr(c, 400, "Сначала выйдите из текущей команды")
+	case errors.Is(err, errHasTeam):
+		jsonErr(c, 400, "Пользователь уже состоит в команде")
+	case errors.Is(err, errRosterLocked):
+		jsonErr(c, 400, "Состав команды зафиксирован заявкой на матч")
+	case errors.Is(err, errTeamFull):
+		jsonErr(c, 400, "В команде уже максимальное количество участников ("+strconv.Itoa(MaxTeamMembers)+")")
+	default:
+		jsonErr(c, 500, "Ошибка сервера")
+	}
+}
+
+/* ===================== TEAM INVITES ===================== */
+
+// sqlInviteActive — приглашение ещё можно принять.
+const sqlInviteActive = "i.status = 'pending' AND i.expires_at > " + sqlNowUTC
+
+// GET /api/my/invites — действующие приглашения в команды
+func MyInvites(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		ctx := context.Background()
+
+		q := sq.Select("i.id", "i.team_id", "t.name", "u.username", "i.created_at", "i.expires_at").
+			From("team_invites i").
+			Join("teams t ON t.id = i.team_id").
+			Join("users u ON u.id = i.invited_by").
+			Where(sq.Eq{"i.user_id": userID}).
+			Where(sqlInviteActive).
+			OrderBy("i.id DESC").
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []TeamInvite{}
+		for rows.Next() {
+			var inv TeamInvite
+			if err := rows.Scan(&inv.ID, &inv.TeamID, &inv.TeamName, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			out = append(out, inv)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// POST /api/invites/:id/accept
+func AcceptInvite(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		inviteID, _ := strconv.Atoi(c.Param("id"))
+		if inviteID <= 0 {
+			jsonErr(c, 400, "Некорректное приглашение")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var teamID int
+		q := sq.Select("i.team_id").
+			From("team_invites i").
+			Where(sq.Eq{"i.id": inviteID, "i.user_id": userID}).
+			Where(sqlInviteActive).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&teamID); err != nil {
+			jsonErr(c, 404, "Приглашение не найдено или истекло")
+			return
+		}
+
+		if err := addTeamMemberTx(ctx, tx, teamID, userID); err != nil {
+			teamMemberErr(c, err, true)
+			return
+		}
+
+		upd := sq.Update("team_invites").
+			Set("status", "accepted").
+			Where(sq.Eq{"id": inviteID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &userID, "accept_team_invite", "Пользователь принял приглашение в команду")
+		c.JSON(200, gin.H{"ok": true, "team_id": teamID})
+	}
+}
+
+// POST /api/invites/:id/decline
+func DeclineInvite(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		inviteID, _ := strconv.Atoi(c.Param("id"))
+		if inviteID <= 0 {
+			jsonErr(c, 400, "Некорректное приглашение")
+			return
+		}
+
+		ctx := context.Background()
+
+		upd := sq.Update("team_invites i").
+			Set("status", "declined").
+			Where(sq.Eq{"i.id": inviteID, "i.user_id": userID}).
+			Where(sqlInviteActive).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExec(ctx, db, upd)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 404, "Приглашение не найдено или истекло")
+			return
+		}
+
+		logAction(db, &userID, "decline_team_invite", "Пользователь отклонил приглашение в команду")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+/* ===================== TEAM JOIN REQUESTS ===================== */
+
+// teamOwnerTx — owner команды и открыта ли она; pgx.ErrNoRows, если команды нет.
+func teamOwnerTx(ctx context.Context, tx pgx.Tx, teamID int) (int, bool, error) {
+	var ownerID int
+	var isOpen bool
+	q := sq.Select("owner_id", "is_open").
+		From("teams").
+		Where(sq.Eq{"id": teamID, "disbanded_at": nil}).
+		PlaceholderFormat(sq.Dollar)
+
+	err := qRowTx(ctx, tx, q).Scan(&ownerID, &isOpen)
+	return ownerID, isOpen, err
+}
+
+// POST /api/teams/:id/request — попроситься в закрытую команду
+func RequestToJoinTeam(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		if teamID <= 0 {
+			jsonErr(c, 400, "Некорректная команда")
+			return
+		}
+
+		has, err := userHasAnyTeam(db, userID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if has {
+			jsonErr(c, 400, "Сначала выйдите из текущей команды")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		_, isOpen, err := teamOwnerTx(ctx, tx, teamID)
+		if err != nil {
+			jsonErr(c, 404, "Команда не найдена")
+			return
+		}
+		if isOpen {
+			jsonErr(c, 400, "Это открытая команда — вступите сами")
+			return
+		}
+
+		ins := sq.Insert("team_join_requests").
+			Columns("team_id", "user_id").
+			Values(teamID, userID).
+			Suffix("ON CONFLICT DO NOTHING RETURNING id").
+			PlaceholderFormat(sq.Dollar)
+
+		var reqID int
+		if err := qRowTx(ctx, tx, ins).Scan(&reqID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				jsonErr(c, 400, "Заявка уже отправлена")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &userID, "request_join_team", "Пользователь попросился в закрытую команду")
+		c.JSON(200, gin.H{"ok": true, "request_id": reqID})
+	}
+}
+
+// GET /api/my/join-requests — свои заявки в команды
+func MyJoinRequests(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		ctx := context.Background()
+
+		q := sq.Select("r.id", "r.team_id", "t.name", "r.user_id", "u.username", "r.status", "r.created_at").
+			From("team_join_requests r").
+			Join("teams t ON t.id = r.team_id").
+			Join("users u ON u.id = r.user_id").
+			Where(sq.Eq{"r.user_id": userID}).
+			OrderBy("r.id DESC").
+			Limit(50).
+			PlaceholderFormat(sq.Dollar)
+
+		out, err := loadJoinRequests(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, out)
+	}
+}
+
+// GET /api/teams/:id/requests — ожидающие заявки (только owner)
+func TeamJoinRequests(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		if teamID <= 0 {
+			jsonErr(c, 400, "Некорректная команда")
+			return
+		}
+
+		ctx := context.Background()
+
+		var ownerID int
+		qOwner := sq.Select("owner_id").From("teams").Where(sq.Eq{"id": teamID, "disbanded_at": nil}).PlaceholderFormat(sq.Dollar)
+		if err := qRow(ctx, db, qOwner).Scan(&ownerID); err != nil {
+			jsonErr(c, 404, "Команда не найдена")
+			return
+		}
+		if ownerID != actor {
+			jsonErr(c, 403, "Заявки видит только создатель команды")
+			return
+		}
+
+		q := sq.Select("r.id", "r.team_id", "t.name", "r.user_id", "u.username", "r.status", "r.created_at").
+			From("team_join_requests r").
+			Join("teams t ON t.id = r.team_id").
+			Join("users u ON u.id = r.user_id").
+			Where(sq.Eq{"r.team_id": teamID, "r.status": "pending"}).
+			OrderBy("r.id ASC").
+			PlaceholderFormat(sq.Dollar)
+
+		out, err := loadJoinRequests(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, out)
+	}
+}
+
+func loadJoinRequests(ctx context.Context, db *pgxpool.Pool, q sq.SelectBuilder) ([]JoinRequest, error) {
+	rows, err := qQuery(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []JoinRequest{}
+	for rows.Next() {
+		var r JoinRequest
+		if err := rows.Scan(&r.ID, &r.TeamID, &r.TeamName, &r.UserID, &r.Username, &r.Status, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// POST /api/teams/:id/requests/:rid/approve
+func ApproveJoinRequest(db *pgxpool.Pool) gin.HandlerFunc {
+	return decideJoinRequest(db, true)
+}
+
+// POST /api/teams/:id/requests/:rid/reject
+func RejectJoinRequest(db *pgxpool.Pool) gin.HandlerFunc {
+	return decideJoinRequest(db, false)
+}
+
+// decideJoinRequest — решение owner по заявке. Одобрение добавляет игрока
+// через addTeamMemberTx в той же транзакции.
+func decideJoinRequest(db *pgxpool.Pool, approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		reqID, _ := strconv.Atoi(c.Param("rid"))
+		if teamID <= 0 || reqID <= 0 {
+			jsonErr(c, 400, "Некорректная заявка")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		ownerID, _, err := teamOwnerTx(ctx, tx, teamID)
+		if err != nil {
+			jsonErr(c, 404, "Команда не найдена")
+			return
+		}
+		if ownerID != actor {
+			jsonErr(c, 403, "Решение по заявке принимает создатель команды")
+			return
+		}
+
+		var userID int
+		q := sq.Select("user_id").
+			From("team_join_requests").
+			Where(sq.Eq{"id": reqID, "team_id": teamID, "status": "pending"}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&userID); err != nil {
+			jsonErr(c, 404, "Заявка не найдена")
+			return
+		}
+
+		status := "rejected"
+		if approve {
+			status = "approved"
+			if err := addTeamMemberTx(ctx, tx, teamID, userID); err != nil {
+				teamMemberErr(c, err, false)
+				return
+			}
+		}
+
+		upd := sq.Update("team_join_requests").
+			Set("status", status).
+			Set("decided_by", actor).
+			Set("decided_at", sq.Expr("now()")).
+			Where(sq.Eq{"id": reqID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if approve {
+			logAction(db, &actor, "approve_join_request", "Создатель принял игрока в команду по заявке")
+		} else {
+			logAction(db, &actor, "reject_join_request", "Создатель отклонил заявку в команду")
+		}
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+/* ===================== TEAM OWNERSHIP ===================== */
+
+var errTeamBusy = errors.New("team is in an active match")
+
+// teamManagerTx — может ли пользователь действовать от имени команды
+// (owner или капитан): подавать и отзывать заявки на матчи. Строки
+// участника и команды блокируются, так что передача владения, снятие
+// капитана или исключение не отзовут право до конца транзакции.
+func teamManagerTx(ctx context.Context, tx pgx.Tx, teamID, userID int) (bool, error) {
+	q := sq.Select("t.owner_id = tm.user_id OR tm.is_captain").
+		From("team_members tm").
+		Join("teams t ON t.id = tm.team_id").
+		Where(sq.Eq{"tm.team_id": teamID, "tm.user_id": userID}).
+		Suffix("FOR UPDATE OF tm, t").
+		PlaceholderFormat(sq.Dollar)
+
+	var ok bool
+	if err := qRowTx(ctx, tx, q).Scan(&ok); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return ok, nil
+}
+
+// teamBusyTx — команда играет прямо сейчас: в составе идущего матча
+// или в незавершённом турнире.
+func teamBusyTx(ctx context.Context, tx pgx.Tx, teamID int) (bool, error) {
+	inMatch := sq.Select("1").
+		From("match_participants mp").
+		Join("matches m ON m.id = mp.match_id").
+		Where(sq.Eq{"mp.team_id": teamID, "m.status": "closed"})
+
+	inTournament := sq.Select("1").
+		From("tournament_entries e").
+		Join("tournaments t ON t.id = e.tournament_id").
+		Where(sq.Eq{"e.team_id": teamID, "t.status": "running"})
+
+	q := sq.Select().
+		Column(sq.Expr("EXISTS(?) OR EXISTS(?)", inMatch, inTournament)).
+		PlaceholderFormat(sq.Dollar)
+
+	var busy bool
+	err := qRowTx(ctx, tx, q).Scan(&busy)
+	return busy, err
+}
+
+// disbandTeamTx распускает команду. Заявки команды на незавершённые матчи
+// отклоняются, её состав убирается из ещё не начавшихся матчей (их места
+// достаются листу ожидания), участники выходят из команды. Сама строка
+// teams остаётся с disbanded_at: на неё ссылаются решения, итоги и рейтинг
+// сыгранных матчей.
+// Команду, которая сейчас играет, распустить нельзя — errTeamBusy.
+func disbandTeamTx(ctx context.Context, tx pgx.Tx, teamID int) error {
+	busy, err := teamBusyTx(ctx, tx, teamID)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return errTeamBusy
+	}
+
+	notFinished := sq.Select("1").
+		From("matches m").
+		Where(sq.Expr("m.id = applications.match_id")).
+		Where(sq.NotEq{"m.status": "finished"})
+
+	updApps := sq.Update("applications").
+		Set("status", "rejected").
+		Where(sq.Eq{"team_id": teamID}).
+		Where(sq.NotEq{"status": []string{"rejected", "withdrawn"}}).
+		Where(sq.Expr("EXISTS(?)", notFinished)).
+		Suffix("RETURNING match_id").
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := qQueryTx(ctx, tx, updApps)
+	if err != nil {
+		return err
+	}
+	freed := []int{}
+	for rows.Next() {
+		var matchID int
+		if err := rows.Scan(&matchID); err != nil {
+			rows.Close()
+			return err
+		}
+		freed = append(freed, matchID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	openMatch := sq.Select("1").
+		From("matches m").
+		Where(sq.Expr("m.id = match_participants.match_id")).
+		Where(sq.Eq{"m.status": "open"})
+
+	delParts := sq.Delete("match_participants").
+		Where(sq.Eq{"team_id": teamID}).
+		Where(sq.Expr("EXISTS(?)", openMatch)).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, delParts); err != nil {
+		return err
+	}
+
+	// освободившиеся места открытых матчей отдаём листу ожидания
+	for _, matchID := range freed {
+		if err := promoteWaitlistTx(ctx, tx, matchID); err != nil {
+			return err
+		}
+	}
+
+	delMembers := sq.Delete("team_members").Where(sq.Eq{"team_id": teamID}).PlaceholderFormat(sq.Dollar)
+	if _, err := qExecTx(ctx, tx, delMembers); err != nil {
+		return err
+	}
+
+	updInvites := sq.Update("team_invites").
+		Set("status", "expired").
+		Where(sq.Eq{"team_id": teamID, "status": "pending"}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, updInvites); err != nil {
+		return err
+	}
+
+	updRequests := sq.Update("team_join_requests").
+		Set("status", "rejected").
+		Set("decided_at", sq.Expr(sqlNowUTC)).
+		Where(sq.Eq{"team_id": teamID, "status": "pending"}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, updRequests); err != nil {
+		return err
+	}
+
+	upd := sq.Update("teams").
+		Set("disbanded_at", sq.Expr(sqlNowUTC)).
+		Set("is_open", false).
+		Where(sq.Eq{"id": teamID}).
+		PlaceholderFormat(sq.Dollar)
+
+	_, err = qExecTx(ctx, tx, upd)
+	return err
+}
+
+// lockOwnedTeamTx блокирует команду и проверяет, что actor — её owner.
+// Ответ клиенту уже отправлен, если вернулось false.
+func lockOwnedTeamTx(ctx context.Context, c *gin.Context, tx pgx.Tx, teamID, actor int) bool {
+	var ownerID int
+	q := sq.Select("owner_id").
+		From("teams").
+		Where(sq.Eq{"id": teamID, "disbanded_at": nil}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, q).Scan(&ownerID); err != nil {
+		jsonErr(c, 404, "Команда не найдена")
+		return false
+	}
+	if ownerID != actor {
+		jsonErr(c, 403, "Только создатель команды может это сделать")
+		return false
+	}
+	return true
+}
+
+func isTeamMemberTx(ctx context.Context, tx pgx.Tx, teamID, userID int) (bool, error) {
+	sub := sq.Select("1").From("team_members").Where(sq.Eq{"team_id": teamID, "user_id": userID})
+	q := sq.Select().Column(sq.Expr("EXISTS(?)", sub)).PlaceholderFormat(sq.Dollar)
+
+	var ok bool
+	err := qRowTx(ctx, tx, q).Scan(&ok)
+	return ok, err
+}
+
+// POST /api/teams/:id/transfer  { "user_id": 123 } — передать команду участнику
+func TransferTeam(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		if teamID <= 0 {
+			jsonErr(c, 400, "Некорректная команда")
+			return
+		}
+
+		var req struct {
+			UserID int `json:"user_id"`
+		}
+		if err := c.BindJSON(&req); err != nil || req.UserID <= 0 || req.UserID == actor {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		if !lockOwnedTeamTx(ctx, c, tx, teamID, actor) {
+			return
+		}
+
+		member, err := isTeamMemberTx(ctx, tx, teamID, req.UserID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if !member {
+			jsonErr(c, 400, "Пользователь не состоит в команде")
+			return
+		}
+
+		upd := sq.Update("teams").
+			Set("owner_id", req.UserID).
+			Where(sq.Eq{"id": teamID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		// новый owner — уже не капитан
+		updM := sq.Update("team_members").
+			Set("is_captain", false).
+			Where(sq.Eq{"team_id": teamID, "user_id": req.UserID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, updM); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "transfer_team", "Создатель передал команду другому участнику")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// POST /api/teams/:id/captain  { "user_id": 123 } — назначить капитана
+// (user_id = 0 снимает капитана). Капитан в команде один.
+func SetTeamCaptain(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		if teamID <= 0 {
+			jsonErr(c, 400, "Некорректная команда")
+			return
+		}
+
+		var req struct {
+			UserID int `json:"user_id"`
+		}
+		if err := c.BindJSON(&req); err != nil || req.UserID < 0 || req.UserID == actor {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		if !lockOwnedTeamTx(ctx, c, tx, teamID, actor) {
+			return
+		}
+
+		if req.UserID > 0 {
+			member, err := isTeamMemberTx(ctx, tx, teamID, req.UserID)
+			if err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			if !member {
+				jsonErr(c, 400, "Пользователь не состоит в команде")
+				return
+			}
+		}
+
+		upd := sq.Update("team_members").
+			Set("is_captain", sq.Expr("(user_id = ?)", req.UserID)).
+			Where(sq.Eq{"team_id": teamID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "set_team_captain", "Создатель назначил капитана команды")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// DELETE /api/teams/:id — распустить команду (только owner)
+func DisbandTeam(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		if teamID <= 0 {
+			jsonErr(c, 400, "Некорректная команда")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		if !lockOwnedTeamTx(ctx, c, tx, teamID, actor) {
+			return
+		}
+
+		if err := disbandTeamTx(ctx, tx, teamID); err != nil {
+			if errors.Is(err, errTeamBusy) {
+				jsonErr(c, 400, "Команда сейчас участвует в матче или турнире")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "disband_team", "Создатель распустил команду")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+/* ===================== TEAM PROFILE ===================== */
+
+// lockEditableTeamTx — lockOwnedTeamTx плюс запрет правок, пока команда
+// заявлена на матч или играет.
+func lockEditableTeamTx(ctx context.Context, c *gin.Context, tx pgx.Tx, teamID, actor int) bool {
+	if !lockOwnedTeamTx(ctx, c, tx, teamID, actor) {
+		return false
+	}
+	locked, err := teamRosterLockedTx(ctx, tx, teamID)
+	if err != nil {
+		jsonErr(c, 500, "Ошибка сервера")
+		return false
+	}
+	if locked {
+		jsonErr(c, 400, "Команда заявлена на матч или участвует в турнире")
+		return false
+	}
+	return true
+}
+
+// PUT /api/teams/:id  { "name": "...", "is_open": true } — поля необязательные
+func UpdateTeam(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		if teamID <= 0 {
+			jsonErr(c, 400, "Некорректная команда")
+			return
+		}
+
+		var req struct {
+			Name   *string `json:"name"`
+			IsOpen *bool   `json:"is_open"`
+		}
+		if err := c.BindJSON(&req); err != nil || (req.Name == nil && req.IsOpen == nil) {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		if req.Name != nil {
+			name := clampRunes(strings.TrimSpace(*req.Name), MaxTeamName)
+			if name == "" {
+				jsonErr(c, 400, "Введите название команды")
+				return
+			}
+			req.Name = &name
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		if !lockEditableTeamTx(ctx, c, tx, teamID, actor) {
+			return
+		}
+
+		upd := sq.Update("teams").
+			Where(sq.Eq{"id": teamID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if req.Name != nil {
+			upd = upd.Set("name", *req.Name)
+		}
+		if req.IsOpen != nil {
+			upd = upd.Set("is_open", *req.IsOpen)
+		}
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if req.Name != nil {
+			logAction(db, &actor, "rename_team", "Команда переименована: "+*req.Name)
+		}
+		if req.IsOpen != nil {
+			if *req.IsOpen {
+				logAction(db, &actor, "team_visibility", "Команда стала открытой")
+			} else {
+				logAction(db, &actor, "team_visibility", "Команда стала закрытой")
+			}
+		}
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// POST /api/teams/:id/kick  { "user_id": 123 }
+func KickTeamMember(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		teamID, _ := strconv.Atoi(c.Param("id"))
+		if teamID <= 0 {
+			jsonErr(c, 400, "Некорректная команда")
+			return
+		}
+
+		var req struct {
+			UserID int `json:"user_id"`
+		}
+		if err := c.BindJSON(&req); err != nil || req.UserID <= 0 {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		if req.UserID == actor {
+			jsonErr(c, 400, "Создатель не может исключить себя — передайте команду или выйдите")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		if !lockEditableTeamTx(ctx, c, tx, teamID, actor) {
+			return
+		}
+
+		del := sq.Delete("team_members").
+			Where(sq.Eq{"team_id": teamID, "user_id": req.UserID}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExecTx(ctx, tx, del)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 400, "Пользователь не состоит в команде")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "kick_team_member", "Создатель исключил участника из команды")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
@@ -0,0 +1,545 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP по RFC 6238: HMAC-SHA1, шаг 30 секунд, 6 цифр. Допускается
+// соседний шаг (расхождение часов); уже использованный шаг повторно не
+// принимается (users.totp_last_step).
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+/* ===================== TOTP ===================== */
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// hotp — RFC 4226 для счётчика counter.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+
+	s := strconv.FormatUint(uint64(code%1_000_000), 10)
+	return strings.Repeat("0", totpDigits-len(s)) + s
+}
+
+// totpStep возвращает шаг, на котором code верен, или 0.
+// Шаги не старше last отбрасываются.
+func totpStep(secret, code string, last int64, now time.Time) int64 {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0
+	}
+
+	cur := now.Unix() / totpPeriod
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		step := cur + d
+		if step <= last {
+			continue
+		}
+		if hmac.Equal([]byte(hotp(key, uint64(step))), []byte(code)) {
+			return step
+		}
+	}
+	return 0
+}
+
+func totpURI(username, secret string) string {
+	label := url.PathEscape(TOTPIssuer + ":" + username)
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", TOTPIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", strconv.Itoa(totpDigits))
+	v.Set("period", strconv.Itoa(totpPeriod))
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+/* ===================== RECOVERY CODES ===================== */
+
+func normRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// newRecoveryCodesTx заменяет коды восстановления пользователя новыми.
+// Коды возвращаются один раз, в БД — только хэши.
+func newRecoveryCodesTx(ctx context.Context, tx pgx.Tx, userID int) ([]string, error) {
+	del := sq.Delete("recovery_codes").Where(sq.Eq{"user_id": userID}).PlaceholderFormat(sq.Dollar)
+	if _, err := qExecTx(ctx, tx, del); err != nil {
+		return nil, err
+	}
+
+	ins := sq.Insert("recovery_codes").Columns("user_id", "code_hash").PlaceholderFormat(sq.Dollar)
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(b32.EncodeToString(b))
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		ins = ins.Values(userID, hashToken(raw))
+	}
+
+	if _, err := qExecTx(ctx, tx, ins); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifySecondFactorTx принимает код TOTP или неиспользованный код
+// восстановления. Пользователь должен быть заблокирован вызывающим.
+func verifySecondFactorTx(ctx context.Context, tx pgx.Tx, userID int, secret string, last int64, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step := totpStep(secret, code, last, time.Now()); step > 0 {
+		upd := sq.Update("users").
+			Set("totp_last_step", step).
+			Where(sq.Eq{"id": userID}).
+			PlaceholderFormat(sq.Dollar)
+
+		_, err := qExecTx(ctx, tx, upd)
+		return err == nil, err
+	}
+
+	use := sq.Update("recovery_codes").
+		Set("used_at", sq.Expr(sqlNowUTC)).
+		Where(sq.Eq{"user_id": userID, "code_hash": hashToken(normRecoveryCode(code)), "used_at": nil}).
+		PlaceholderFormat(sq.Dollar)
+
+	tag, err := qExecTx(ctx, tx, use)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// lockTOTPTx — настройки 2FA пользователя под блокировкой.
+func lockTOTPTx(ctx context.Context, tx pgx.Tx, userID int) (secret *string, enabled bool, last int64, err error) {
+	q := sq.Select("totp_secret", "totp_enabled", "totp_last_step").
+		From("users").
+		Where(sq.Eq{"id": userID}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	err = qRowTx(ctx, tx, q).Scan(&secret, &enabled, &last)
+	return
+}
+
+/* ===================== LOGIN: SECOND STEP ===================== */
+
+// startMFAChallenge — первый шаг входа пройден, нужен код. Выдаётся
+// короткоживущий одноразовый токен с ограниченным числом попыток.
+func startMFAChallenge(ctx context.Context, db *pgxpool.Pool, userID int) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	ins := sq.Insert("login_challenges").
+		Columns("user_id", "token_hash", "expires_at").
+		Values(userID, hashToken(token), sq.Expr(sqlNowUTC+" + make_interval(mins => ?)", MFAChallengeMinutes)).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExec(ctx, db, ins); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// POST /api/auth/login/2fa  { "challenge": "...", "code": "123456" | "abcd-efgh" }
+func LoginSecondFactor(db *pgxpool.Pool, secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Challenge string `json:"challenge"`
+			Code      string `json:"code"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "bad json"})
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var challengeID int64
+		var userID, attempts int
+		q := sq.Select("id", "user_id", "attempts").
+			From("login_challenges").
+			Where(sq.Eq{"token_hash": hashToken(req.Challenge)}).
+			Where(sq.Expr("expires_at > " + sqlNowUTC)).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, q).Scan(&challengeID, &userID, &attempts); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				c.JSON(401, gin.H{"error": "challenge expired"})
+				return
+			}
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+
+		totpSecret, enabled, last, err := lockTOTPTx(ctx, tx, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		if !enabled || totpSecret == nil {
+			c.JSON(401, gin.H{"error": "challenge expired"})
+			return
+		}
+
+		// неудачи кода считаются вместе с неудачами пароля: блокировка
+		// по логину или IP действует и на второй шаг
+		var username string
+		qU := sq.Select("username").From("users").Where(sq.Eq{"id": userID}).PlaceholderFormat(sq.Dollar)
+		if err := qRowTx(ctx, tx, qU).Scan(&username); err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		ip := c.ClientIP()
+
+		wait, err := loginRetryAfter(ctx, db, username, ip)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		if wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(wait))
+			c.JSON(429, gin.H{"error": "too many attempts", "retry_after": wait})
+			return
+		}
+
+		ok, err := verifySecondFactorTx(ctx, tx, userID, *totpSecret, last, req.Code)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+
+		if !ok {
+			// попытки считаются на токене; исчерпанный токен удаляется
+			var upd sq.Sqlizer
+			if attempts+1 >= MaxMFAAttempts {
+				upd = sq.Delete("login_challenges").Where(sq.Eq{"id": challengeID}).PlaceholderFormat(sq.Dollar)
+			} else {
+				upd = sq.Update("login_challenges").Set("attempts", attempts+1).Where(sq.Eq{"id": challengeID}).PlaceholderFormat(sq.Dollar)
+			}
+			if _, err := qExecTx(ctx, tx, upd); err != nil || tx.Commit(ctx) != nil {
+				c.JSON(500, gin.H{"error": "server error"})
+				return
+			}
+			_ = recordLoginFailure(ctx, db, &userID, username, ip)
+			c.JSON(401, gin.H{"error": "invalid code"})
+			return
+		}
+
+		del := sq.Delete("login_challenges").Where(sq.Eq{"id": challengeID}).PlaceholderFormat(sq.Dollar)
+		if _, err := qExecTx(ctx, tx, del); err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+
+		if err := startSession(c, db, secret, userID, true); err != nil {
+			c.JSON(500, gin.H{"error": "server error"})
+			return
+		}
+		clearLoginFailures(ctx, db, username)
+
+		logAction(db, &userID, "login", "success (2fa)")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+/* ===================== ENROLLMENT ===================== */
+
+// GET /api/me/2fa
+func TwoFactorStatus(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		ctx := context.Background()
+
+		left := sq.Select("COUNT(*)").
+			From("recovery_codes r").
+			Where(sq.Expr("r.user_id = u.id")).
+			Where(sq.Eq{"r.used_at": nil})
+
+		q := sq.Select("u.totp_enabled", "u.role").
+			Column(sq.Expr("(?)", left)).
+			From("users u").
+			Where(sq.Eq{"u.id": userID}).
+			PlaceholderFormat(sq.Dollar)
+
+		var enabled bool
+		var role string
+		var codesLeft int
+		if err := qRow(ctx, db, q).Scan(&enabled, &role, &codesLeft); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		mfa, _ := c.Get("mfa")
+		c.JSON(200, gin.H{
+			"enabled":             enabled,
+			"required":            role == "admin",
+			"session_verified":    mfa == true,
+			"recovery_codes_left": codesLeft,
+		})
+	}
+}
+
+// POST /api/me/2fa/setup
+// Новый секрет (ещё не включён) и otpauth:// URI для QR-кода.
+func TwoFactorSetup(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+		ctx := context.Background()
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		upd := sq.Update("users").
+			Set("totp_secret", secret).
+			Set("totp_last_step", 0).
+			Where(sq.Eq{"id": userID, "totp_enabled": false}).
+			Suffix("RETURNING username").
+			PlaceholderFormat(sq.Dollar)
+
+		var username string
+		if err := qRow(ctx, db, upd).Scan(&username); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				jsonErr(c, 400, "2FA уже включена")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		c.JSON(200, gin.H{"secret": secret, "uri": totpURI(username, secret)})
+	}
+}
+
+// POST /api/me/2fa/enable  { "code": "123456" }
+// Включает 2FA после проверки кода и выдаёт коды восстановления (один раз).
+// Текущая сессия считается прошедшей второй фактор.
+func TwoFactorEnable(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		secret, enabled, last, err := lockTOTPTx(ctx, tx, userID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if enabled {
+			jsonErr(c, 400, "2FA уже включена")
+			return
+		}
+		if secret == nil {
+			jsonErr(c, 400, "Сначала получите секрет")
+			return
+		}
+
+		step := totpStep(*secret, strings.TrimSpace(req.Code), last, time.Now())
+		if step == 0 {
+			jsonErr(c, 400, "Неверный код")
+			return
+		}
+
+		upd := sq.Update("users").
+			Set("totp_enabled", true).
+			Set("totp_last_step", step).
+			Where(sq.Eq{"id": userID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		codes, err := newRecoveryCodesTx(ctx, tx, userID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		mark := sq.Update("sessions").
+			Set("mfa", true).
+			Where(sq.Eq{"id": currentSession(c)}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, mark); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		invalidateUser(userID)
+
+		logAction(db, &userID, "enable_2fa", "Пользователь включил 2FA")
+		c.JSON(200, gin.H{"ok": true, "recovery_codes": codes})
+	}
+}
+
+// POST /api/me/2fa/disable  { "password": "...", "code": "123456" }
+// Администратору 2FA обязательна — отключить нельзя.
+func TwoFactorDisable(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := uid(c)
+
+		var req struct {
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		secret, enabled, last, err := lockTOTPTx(ctx, tx, userID)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if !enabled || secret == nil {
+			jsonErr(c, 400, "2FA не включена")
+			return
+		}
+
+		var role, passHash string
+		qU := sq.Select("role", "pass_hash").From("users").Where(sq.Eq{"id": userID}).PlaceholderFormat(sq.Dollar)
+		if err := qRowTx(ctx, tx, qU).Scan(&role, &passHash); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if role == "admin" {
+			jsonErr(c, 400, "Администратору 2FA обязательна")
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passHash), []byte(req.Password)) != nil {
+			jsonErr(c, 400, "Неверный пароль")
+			return
+		}
+
+		ok, err := verifySecondFactorTx(ctx, tx, userID, *secret, last, req.Code)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if !ok {
+			jsonErr(c, 400, "Неверный код")
+			return
+		}
+
+		upd := sq.Update("users").
+			Set("totp_enabled", false).
+			Set("totp_secret", nil).
+			Set("totp_last_step", 0).
+			Where(sq.Eq{"id": userID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		del := sq.Delete("recovery_codes").Where(sq.Eq{"user_id": userID}).PlaceholderFormat(sq.Dollar)
+		if _, err := qExecTx(ctx, tx, del); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &userID, "disable_2fa", "Пользователь отключил 2FA")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// RequireMFA — после Auth: сессия должна быть подтверждена вторым фактором.
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if mfa, _ := c.Get("mfa"); mfa != true {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "2fa required"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ключ из приложений RFC 4226 и RFC 6238 (SHA1)
+var rfcKey = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226, приложение D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp(rfcKey, uint64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPStep(t *testing.T) {
+	secret := b32.EncodeToString(rfcKey)
+
+	// RFC 6238, приложение B (последние 6 из 8 цифр)
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		now := time.Unix(v.unix, 0)
+		want := v.unix / totpPeriod
+		if got := totpStep(secret, v.code, 0, now); got != want {
+			t.Errorf("t=%d: totpStep = %d, want %d", v.unix, got, want)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+	code := hotp(rfcKey, uint64(step))
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		last   int64
+		now    time.Time
+		want   int64
+	}{
+		{"lowercase secret", strings.ToLower(secret), code, 0, now, step},
+		{"previous step within skew", secret, code, 0, now.Add(totpPeriod * time.Second), step},
+		{"next step within skew", secret, code, 0, now.Add(-totpPeriod * time.Second), step},
+		{"outside skew", secret, code, 0, now.Add(2 * totpPeriod * time.Second), 0},
+		{"already used step", secret, code, step, now, 0},
+		{"wrong code", secret, "000000", 0, now, 0},
+		{"short code", secret, code[:5], 0, now, 0},
+		{"bad secret", "not base32!", code, 0, now, 0},
+	}
+	for _, tt := range tests {
+		if got := totpStep(tt.secret, tt.code, tt.last, tt.now); got != tt.want {
+			t.Errorf("%s: totpStep = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
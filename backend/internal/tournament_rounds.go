@@ -0,0 +1,386 @@
+package internal
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// roundConfig — то, что нужно для создания очередного тура.
+type roundConfig struct {
+	ID        int
+	Mode      string
+	Format    string
+	Status    string
+	Round     int
+	Rounds    *int
+	CreatedBy int
+}
+
+// swissSearchLimit ограничивает перебор при поиске пар без повторных встреч.
+const swissSearchLimit = 200_000
+
+/* ===================== STANDINGS ===================== */
+
+func (st Standing) entrant() entrant {
+	if st.TeamID != nil {
+		return entrant{TeamID: *st.TeamID}
+	}
+	if st.UserID != nil {
+		return entrant{UserID: *st.UserID}
+	}
+	return entrant{}
+}
+
+// tournamentStandingsTx считает таблицу турнира по сыгранным матчам:
+// победа — 1 очко, матч без победителя — по 0.5, bye — победа.
+// Бухгольц — сумма очков соперников. Порядок: очки, Бухгольц, посев.
+func tournamentStandingsTx(ctx context.Context, tx pgx.Tx, tournamentID int) ([]Standing, error) {
+	qE := sq.Select("e.user_id", "e.team_id", "e.seed", "COALESCE(t.name, u.username, '')").
+		From("tournament_entries e").
+		LeftJoin("users u ON u.id = e.user_id").
+		LeftJoin("teams t ON t.id = e.team_id").
+		Where(sq.Eq{"e.tournament_id": tournamentID}).
+		OrderBy("e.seed").
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := qQueryTx(ctx, tx, qE)
+	if err != nil {
+		return nil, err
+	}
+	byEntrant := map[entrant]*Standing{}
+	order := []entrant{}
+	for rows.Next() {
+		st := &Standing{}
+		if err := rows.Scan(&st.UserID, &st.TeamID, &st.seed, &st.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e := st.entrant()
+		byEntrant[e] = st
+		order = append(order, e)
+	}
+	rows.Close()
+
+	type played struct {
+		winner   entrant
+		entrants []entrant
+	}
+
+	qM := sq.Select("m.id", "m.winner_user_id", "m.winner_team_id", "mp.user_id", "mp.team_id").
+		From("matches m").
+		Join("match_participants mp ON mp.match_id = m.id").
+		Where(sq.Eq{"m.tournament_id": tournamentID, "m.status": "finished"}).
+		OrderBy("m.id").
+		PlaceholderFormat(sq.Dollar)
+
+	rowsM, err := qQueryTx(ctx, tx, qM)
+	if err != nil {
+		return nil, err
+	}
+	matches := map[int]*played{}
+	matchOrder := []int{}
+	for rowsM.Next() {
+		var mid, userID int
+		var wu, wt, teamID *int
+		if err := rowsM.Scan(&mid, &wu, &wt, &userID, &teamID); err != nil {
+			rowsM.Close()
+			return nil, err
+		}
+		p, ok := matches[mid]
+		if !ok {
+			p = &played{}
+			if wt != nil {
+				p.winner = entrant{TeamID: *wt}
+			} else if wu != nil {
+				p.winner = entrant{UserID: *wu}
+			}
+			matches[mid] = p
+			matchOrder = append(matchOrder, mid)
+		}
+		e := entrantOf(userID, teamID)
+		dup := false
+		for _, x := range p.entrants {
+			if x == e {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			p.entrants = append(p.entrants, e)
+		}
+	}
+	rowsM.Close()
+
+	for _, mid := range matchOrder {
+		p := matches[mid]
+		if len(p.entrants) == 1 {
+			if st, ok := byEntrant[p.entrants[0]]; ok {
+				st.Byes++
+				st.Wins++
+				st.Points++
+			}
+			continue
+		}
+		for _, e := range p.entrants {
+			st, ok := byEntrant[e]
+			if !ok {
+				continue
+			}
+			st.Played++
+			for _, o := range p.entrants {
+				if o != e {
+					st.opponents = append(st.opponents, o)
+				}
+			}
+			switch {
+			case p.winner == (entrant{}):
+				st.Draws++
+				st.Points += 0.5
+			case p.winner == e:
+				st.Wins++
+				st.Points++
+			default:
+				st.Losses++
+			}
+		}
+	}
+
+	return rankStandings(byEntrant, order), nil
+}
+
+// rankStandings досчитывает Бухгольц и расставляет места.
+// order — участники в порядке посева.
+func rankStandings(byEntrant map[entrant]*Standing, order []entrant) []Standing {
+	out := make([]Standing, 0, len(order))
+	for _, e := range order {
+		st := byEntrant[e]
+		for _, o := range st.opponents {
+			if os, ok := byEntrant[o]; ok {
+				st.Buchholz += os.Points
+			}
+		}
+	}
+	for _, e := range order {
+		out = append(out, *byEntrant[e])
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		return a.seed < b.seed
+	})
+	for i := range out {
+		out[i].Rank = i + 1
+	}
+	return out
+}
+
+/* ===================== PAIRINGS ===================== */
+
+// roundRobinPairs — пары тура r (с 1) по круговому методу. Участники
+// передаются в порядке посева; при нечётном числе один получает bye
+// (пара с пустым entrant).
+func roundRobinPairs(entrants []entrant, r int) [][2]entrant {
+	list := append([]entrant{}, entrants...)
+	if len(list)%2 == 1 {
+		list = append(list, entrant{})
+	}
+	n := len(list)
+	if n < 2 {
+		return nil
+	}
+
+	// первый стоит на месте, остальные сдвигаются на r-1 позиций
+	rest := list[1:]
+	shift := (r - 1) % len(rest)
+	rotated := append([]entrant{list[0]}, append(append([]entrant{}, rest[len(rest)-shift:]...), rest[:len(rest)-shift]...)...)
+
+	pairs := make([][2]entrant, 0, n/2)
+	for i := 0; i < n/2; i++ {
+		pairs = append(pairs, [2]entrant{rotated[i], rotated[n-1-i]})
+	}
+	return pairs
+}
+
+func roundRobinRounds(count int) int {
+	if count%2 == 1 {
+		return count
+	}
+	return count - 1
+}
+
+// swissPairs — пары очередного тура швейцарки. Участники идут в порядке
+// текущей таблицы; bye получает самый нижний, у кого его ещё не было.
+// Повторных встреч избегаем перебором, а если это невозможно — сводим
+// соседей по таблице.
+func swissPairs(standings []Standing) [][2]entrant {
+	pool := make([]entrant, 0, len(standings))
+	for _, st := range standings {
+		pool = append(pool, st.entrant())
+	}
+
+	met := map[[2]entrant]bool{}
+	for _, st := range standings {
+		for _, o := range st.opponents {
+			met[[2]entrant{st.entrant(), o}] = true
+		}
+	}
+
+	pairs := [][2]entrant{}
+	if len(pool)%2 == 1 {
+		byeIdx := len(pool) - 1
+		for i := len(standings) - 1; i >= 0; i-- {
+			if standings[i].Byes == 0 {
+				byeIdx = i
+				break
+			}
+		}
+		pairs = append(pairs, [2]entrant{pool[byeIdx], {}})
+		pool = append(pool[:byeIdx:byeIdx], pool[byeIdx+1:]...)
+	}
+
+	steps := 0
+	var search func(rest []entrant) ([][2]entrant, bool)
+	search = func(rest []entrant) ([][2]entrant, bool) {
+		if len(rest) == 0 {
+			return nil, true
+		}
+		a := rest[0]
+		for i := 1; i < len(rest); i++ {
+			steps++
+			if steps > swissSearchLimit {
+				return nil, false
+			}
+			b := rest[i]
+			if met[[2]entrant{a, b}] {
+				continue
+			}
+			left := make([]entrant, 0, len(rest)-2)
+			left = append(left, rest[1:i]...)
+			left = append(left, rest[i+1:]...)
+			if p, ok := search(left); ok {
+				return append([][2]entrant{{a, b}}, p...), true
+			}
+		}
+		return nil, false
+	}
+
+	if p, ok := search(pool); ok {
+		return append(pairs, p...)
+	}
+	for i := 0; i+1 < len(pool); i += 2 {
+		pairs = append(pairs, [2]entrant{pool[i], pool[i+1]})
+	}
+	return pairs
+}
+
+// nextRoundTx создаёт следующий тур круговой системы или швейцарки.
+// false — все туры сыграны.
+func nextRoundTx(ctx context.Context, tx pgx.Tx, t roundConfig) (bool, error) {
+	standings, err := tournamentStandingsTx(ctx, tx, t.ID)
+	if err != nil {
+		return false, err
+	}
+
+	var total int
+	if t.Format == "swiss" && t.Rounds != nil {
+		total = *t.Rounds
+	} else {
+		total = roundRobinRounds(len(standings))
+	}
+	if t.Round >= total {
+		return false, nil
+	}
+	round := t.Round + 1
+
+	var pairs [][2]entrant
+	if t.Format == "swiss" {
+		pairs = swissPairs(standings)
+	} else {
+		bySeed := append([]Standing{}, standings...)
+		sort.SliceStable(bySeed, func(i, j int) bool { return bySeed[i].seed < bySeed[j].seed })
+		entrants := make([]entrant, len(bySeed))
+		for i, st := range bySeed {
+			entrants[i] = st.entrant()
+		}
+		pairs = roundRobinPairs(entrants, round)
+	}
+
+	for i, p := range pairs {
+		title := "R" + strconv.Itoa(round) + "-" + strconv.Itoa(i+1)
+		ins := sq.Insert("matches").
+			Columns("title", "mode", "status", "created_by", "tournament_id", "round", "position", "season_id").
+			Values(title, t.Mode, "open", t.CreatedBy, t.ID, round, i, sq.Expr(currentSeasonSQL)).
+			Suffix("RETURNING id").
+			PlaceholderFormat(sq.Dollar)
+
+		var matchID int
+		if err := qRowTx(ctx, tx, ins).Scan(&matchID); err != nil {
+			return false, err
+		}
+		for _, e := range p {
+			if e == (entrant{}) {
+				continue // bye: матч с одним участником закроется автоматически
+			}
+			if err := addEntrantToMatchTx(ctx, tx, matchID, e, 0); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	upd := sq.Update("tournaments").
+		Set("current_round", round).
+		Where(sq.Eq{"id": t.ID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if _, err := qExecTx(ctx, tx, upd); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+/* ===================== STANDINGS (USER) ===================== */
+
+// GET /api/tournaments/:id/standings
+func TournamentStandings(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный турнир")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var format string
+		var round int
+		qT := sq.Select("format", "current_round").From("tournaments").Where(sq.Eq{"id": id}).PlaceholderFormat(sq.Dollar)
+		if err := qRowTx(ctx, tx, qT).Scan(&format, &round); err != nil {
+			jsonErr(c, 404, "Турнир не найден")
+			return
+		}
+
+		standings, err := tournamentStandingsTx(ctx, tx, id)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		c.JSON(200, gin.H{"format": format, "round": round, "standings": standings})
+	}
+}
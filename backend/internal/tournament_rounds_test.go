@@ -0,0 +1,161 @@
+package internal
+
+import "testing"
+
+func testEntrants(n int) []entrant {
+	out := make([]entrant, n)
+	for i := range out {
+		out[i] = entrant{UserID: i + 1}
+	}
+	return out
+}
+
+func pairKey(a, b entrant) [2]entrant {
+	if b.UserID < a.UserID {
+		a, b = b, a
+	}
+	return [2]entrant{a, b}
+}
+
+func TestRoundRobinPairs(t *testing.T) {
+	for _, n := range []int{2, 3, 4, 5, 6, 7, 8} {
+		entrants := testEntrants(n)
+		rounds := roundRobinRounds(n)
+
+		met := map[[2]entrant]int{}
+		byes := map[entrant]int{}
+		for r := 1; r <= rounds; r++ {
+			seen := map[entrant]bool{}
+			for _, p := range roundRobinPairs(entrants, r) {
+				for _, e := range p {
+					if e == (entrant{}) {
+						continue
+					}
+					if seen[e] {
+						t.Fatalf("n=%d round %d: %v plays twice", n, r, e)
+					}
+					seen[e] = true
+				}
+				switch {
+				case p[1] == (entrant{}):
+					byes[p[0]]++
+				case p[0] == (entrant{}):
+					byes[p[1]]++
+				default:
+					met[pairKey(p[0], p[1])]++
+				}
+			}
+			if len(seen) != n {
+				t.Fatalf("n=%d round %d: %d entrants paired, want %d", n, r, len(seen), n)
+			}
+		}
+
+		// каждый встречается с каждым ровно один раз
+		if len(met) != n*(n-1)/2 {
+			t.Errorf("n=%d: %d distinct pairs, want %d", n, len(met), n*(n-1)/2)
+		}
+		for k, c := range met {
+			if c != 1 {
+				t.Errorf("n=%d: %v met %d times", n, k, c)
+			}
+		}
+		if n%2 == 1 {
+			for _, e := range entrants {
+				if byes[e] != 1 {
+					t.Errorf("n=%d: %v got %d byes, want 1", n, e, byes[e])
+				}
+			}
+		}
+	}
+}
+
+func testStanding(id int, points float64, byes int, opponents ...int) Standing {
+	st := Standing{UserID: &id, Points: points, Byes: byes, seed: id}
+	for _, o := range opponents {
+		st.opponents = append(st.opponents, entrant{UserID: o})
+	}
+	return st
+}
+
+func TestSwissPairsAvoidsRematch(t *testing.T) {
+	// соседи по таблице уже встречались: 1-2 и 3-4
+	standings := []Standing{
+		testStanding(1, 1, 0, 2),
+		testStanding(2, 1, 0, 1),
+		testStanding(3, 0, 0, 4),
+		testStanding(4, 0, 0, 3),
+	}
+	pairs := swissPairs(standings)
+	if len(pairs) != 2 {
+		t.Fatalf("got %d pairs, want 2", len(pairs))
+	}
+	for _, p := range pairs {
+		k := pairKey(p[0], p[1])
+		if k == pairKey(entrant{UserID: 1}, entrant{UserID: 2}) || k == pairKey(entrant{UserID: 3}, entrant{UserID: 4}) {
+			t.Errorf("rematch %v", p)
+		}
+	}
+	if pairs[0] != [2]entrant{{UserID: 1}, {UserID: 3}} {
+		t.Errorf("leader paired with %v, want the next one in the table they have not met", pairs[0])
+	}
+}
+
+func TestSwissPairsBye(t *testing.T) {
+	// нижний уже получал bye — свободный тур достаётся следующему снизу
+	standings := []Standing{
+		testStanding(1, 2, 0),
+		testStanding(2, 1, 0),
+		testStanding(3, 1, 0),
+		testStanding(4, 1, 0),
+		testStanding(5, 1, 1),
+	}
+	pairs := swissPairs(standings)
+	if len(pairs) != 3 {
+		t.Fatalf("got %d pairs, want 3", len(pairs))
+	}
+	if pairs[0] != [2]entrant{{UserID: 4}, {}} {
+		t.Errorf("bye went to %v, want user 4", pairs[0])
+	}
+}
+
+func TestSwissPairsFallback(t *testing.T) {
+	// больше не с кем играть — сводим соседей, несмотря на повтор
+	standings := []Standing{
+		testStanding(1, 1, 0, 2),
+		testStanding(2, 0, 0, 1),
+	}
+	pairs := swissPairs(standings)
+	if len(pairs) != 1 || pairKey(pairs[0][0], pairs[0][1]) != pairKey(entrant{UserID: 1}, entrant{UserID: 2}) {
+		t.Errorf("got %v, want 1-2", pairs)
+	}
+}
+
+func TestRankStandingsBuchholz(t *testing.T) {
+	list := []Standing{
+		testStanding(1, 2, 0, 3, 4),
+		testStanding(2, 1, 0, 4, 3),
+		testStanding(3, 1, 0, 1, 2),
+		testStanding(4, 0, 0, 2, 1),
+	}
+	byEntrant := map[entrant]*Standing{}
+	order := []entrant{}
+	for i := range list {
+		e := list[i].entrant()
+		byEntrant[e] = &list[i]
+		order = append(order, e)
+	}
+
+	out := rankStandings(byEntrant, order)
+
+	// у 2 и 3 по очку, но соперники 3 набрали больше
+	wantIDs := []int{1, 3, 2, 4}
+	wantBuchholz := map[int]float64{1: 1, 2: 1, 3: 3, 4: 3}
+	for i, st := range out {
+		if *st.UserID != wantIDs[i] || st.Rank != i+1 {
+			t.Errorf("rank %d: user %d (rank %d), want user %d", i+1, *st.UserID, st.Rank, wantIDs[i])
+		}
+		if st.Buchholz != wantBuchholz[*st.UserID] {
+			t.Errorf("user %d: Buchholz %v, want %v", *st.UserID, st.Buchholz, wantBuchholz[*st.UserID])
+		}
+	}
+}
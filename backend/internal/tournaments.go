@@ -0,0 +1,973 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/* ===================== TOURNAMENTS: BRACKET PLAN ===================== */
+
+func normFormat(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "single_elim", "double_elim", "round_robin", "swiss":
+		return s
+	default:
+		return "invalid"
+	}
+}
+
+// bracketSeedOrder — расстановка посевов по позициям первого раунда
+// (1-8-4-5-2-7-3-6 для 8 мест), чтобы сильнейшие встречались как можно позже.
+func bracketSeedOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		n := len(order) * 2
+		next := make([]int, 0, n)
+		for _, s := range order {
+			next = append(next, s, n+1-s)
+		}
+		order = next
+	}
+	return order
+}
+
+type bracketMatch struct {
+	Bracket   string // upper|lower|final
+	Round     int
+	Position  int
+	ID        int
+	Next      *bracketMatch
+	NextLoser *bracketMatch
+}
+
+func (bm *bracketMatch) title(format string, lastUpper int) string {
+	switch {
+	case bm.Bracket == "final":
+		return "GF"
+	case format == "single_elim" && bm.Round == lastUpper:
+		return "Final"
+	case format == "single_elim":
+		return "R" + strconv.Itoa(bm.Round) + "-" + strconv.Itoa(bm.Position+1)
+	case bm.Bracket == "upper":
+		return "W" + strconv.Itoa(bm.Round) + "-" + strconv.Itoa(bm.Position+1)
+	default:
+		return "L" + strconv.Itoa(bm.Round) + "-" + strconv.Itoa(bm.Position+1)
+	}
+}
+
+// planBracket строит сетку на size = 2^k мест.
+// Верхняя сетка: раунд r содержит 2^(k-r) матчей, победитель (r,i) идёт в (r+1,i/2).
+// Нижняя сетка (double elimination) — 2(k-1) раундов: нечётные сводят
+// победителей нижней сетки попарно, чётные добавляют проигравших верхней.
+// Победители обеих сеток встречаются в гранд-финале.
+func planBracket(format string, size int) (upper [][]*bracketMatch, lower [][]*bracketMatch, final *bracketMatch) {
+	k := 0
+	for 1<<k < size {
+		k++
+	}
+
+	for r := 1; r <= k; r++ {
+		round := make([]*bracketMatch, 1<<(k-r))
+		for i := range round {
+			round[i] = &bracketMatch{Bracket: "upper", Round: r, Position: i}
+		}
+		upper = append(upper, round)
+	}
+	for r := 0; r < k-1; r++ {
+		for i, bm := range upper[r] {
+			bm.Next = upper[r+1][i/2]
+		}
+	}
+
+	if format != "double_elim" || k < 2 {
+		return upper, nil, nil
+	}
+
+	for j := 1; j <= 2*(k-1); j++ {
+		var n int
+		if j%2 == 0 {
+			n = 1 << (k - 1 - j/2)
+		} else {
+			n = 1 << (k - 2 - (j-1)/2)
+		}
+		round := make([]*bracketMatch, n)
+		for i := range round {
+			round[i] = &bracketMatch{Bracket: "lower", Round: j, Position: i}
+		}
+		lower = append(lower, round)
+	}
+	final = &bracketMatch{Bracket: "final", Round: 1}
+
+	// проигравшие верхней сетки
+	for i, bm := range upper[0] {
+		bm.NextLoser = lower[0][i/2]
+	}
+	for r := 2; r <= k; r++ {
+		for i, bm := range upper[r-1] {
+			bm.NextLoser = lower[2*(r-1)-1][i]
+		}
+	}
+	upper[k-1][0].Next = final
+
+	// движение по нижней сетке
+	for j := 1; j <= len(lower); j++ {
+		for i, bm := range lower[j-1] {
+			switch {
+			case j == len(lower):
+				bm.Next = final
+			case j%2 == 1:
+				bm.Next = lower[j][i]
+			default:
+				bm.Next = lower[j][i/2]
+			}
+		}
+	}
+	return upper, lower, final
+}
+
+/* ===================== TOURNAMENTS: PROGRESSION ===================== */
+
+// matchEntrantsTx — участники зачёта матча в порядке добавления.
+func matchEntrantsTx(ctx context.Context, tx pgx.Tx, matchID int) ([]entrant, error) {
+	q := sq.Select("user_id", "team_id").
+		From("match_participants").
+		Where(sq.Eq{"match_id": matchID}).
+		OrderBy("team_id NULLS FIRST", "user_id").
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := qQueryTx(ctx, tx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[entrant]bool{}
+	out := []entrant{}
+	for rows.Next() {
+		var userID int
+		var teamID *int
+		if err := rows.Scan(&userID, &teamID); err != nil {
+			return nil, err
+		}
+		e := entrantOf(userID, teamID)
+		if !seen[e] {
+			seen[e] = true
+			out = append(out, e)
+		}
+	}
+	return out, rows.Err()
+}
+
+// addEntrantToMatchTx записывает участника турнира в матч. Команда выступает
+// тем составом, с которым играла в матче fromMatchID, а в первом раунде
+// (fromMatchID == 0) — текущим составом team_members.
+func addEntrantToMatchTx(ctx context.Context, tx pgx.Tx, matchID int, e entrant, fromMatchID int) error {
+	if e.TeamID == 0 {
+		ins := sq.Insert("match_participants").
+			Columns("match_id", "user_id", "team_id").
+			Values(matchID, e.UserID, nil).
+			Suffix("ON CONFLICT DO NOTHING").
+			PlaceholderFormat(sq.Dollar)
+
+		_, err := qExecTx(ctx, tx, ins)
+		return err
+	}
+
+	var roster sq.SelectBuilder
+	if fromMatchID > 0 {
+		roster = sq.Select().
+			Column(sq.Expr("?::int", matchID)).
+			Columns("user_id", "team_id").
+			From("match_participants").
+			Where(sq.Eq{"match_id": fromMatchID, "team_id": e.TeamID})
+	} else {
+		roster = sq.Select().
+			Column(sq.Expr("?::int", matchID)).
+			Columns("user_id", "team_id").
+			From("team_members").
+			Where(sq.Eq{"team_id": e.TeamID})
+	}
+
+	ins := sq.Insert("match_participants").
+		Columns("match_id", "user_id", "team_id").
+		Select(roster).
+		Suffix("ON CONFLICT DO NOTHING").
+		PlaceholderFormat(sq.Dollar)
+
+	_, err := qExecTx(ctx, tx, ins)
+	return err
+}
+
+// advanceFromMatchTx переносит победителя (и проигравших — в нижнюю сетку)
+// в следующие матчи. Возвращает id турнира или 0, если матч не турнирный.
+func advanceFromMatchTx(ctx context.Context, tx pgx.Tx, matchID int, winner entrant) (int, error) {
+	var tournamentID, next, nextLoser *int
+	q := sq.Select("tournament_id", "next_match_id", "next_loser_match_id").
+		From("matches").
+		Where(sq.Eq{"id": matchID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, q).Scan(&tournamentID, &next, &nextLoser); err != nil {
+		return 0, err
+	}
+	if tournamentID == nil {
+		return 0, nil
+	}
+
+	if next != nil && winner != (entrant{}) {
+		if err := addEntrantToMatchTx(ctx, tx, *next, winner, matchID); err != nil {
+			return 0, err
+		}
+	}
+	if nextLoser != nil {
+		entrants, err := matchEntrantsTx(ctx, tx, matchID)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entrants {
+			if e == winner {
+				continue
+			}
+			if err := addEntrantToMatchTx(ctx, tx, *nextLoser, e, matchID); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return *tournamentID, nil
+}
+
+// resolveWalkoversTx закрывает матчи, которые уже не нужно играть (bye: все
+// предшествующие матчи сыграны, а участник остался один или никого).
+func resolveWalkoversTx(ctx context.Context, tx pgx.Tx, tournamentID int) error {
+	for {
+		feeders := sq.Select("1").
+			From("matches f").
+			Where(sq.Expr("f.tournament_id = m.tournament_id")).
+			Where(sq.NotEq{"f.status": "finished"}).
+			Where(sq.Expr("(f.next_match_id = m.id OR f.next_loser_match_id = m.id)"))
+
+		q := sq.Select("m.id").
+			From("matches m").
+			Where(sq.Eq{"m.tournament_id": tournamentID}).
+			Where(sq.NotEq{"m.status": "finished"}).
+			Where(sq.Expr("NOT EXISTS(?)", feeders)).
+			OrderBy("m.id").
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQueryTx(ctx, tx, q)
+		if err != nil {
+			return err
+		}
+		ready := []int{}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ready = append(ready, id)
+		}
+		rows.Close()
+
+		progressed := false
+		for _, id := range ready {
+			entrants, err := matchEntrantsTx(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if len(entrants) > 1 {
+				continue
+			}
+
+			var winner entrant
+			if len(entrants) == 1 {
+				winner = entrants[0]
+			}
+			updM := sq.Update("matches").
+				Set("status", "finished").
+				Set("winner_user_id", winner.userPtr()).
+				Set("winner_team_id", winner.teamPtr()).
+				Where(sq.Eq{"id": id}).
+				PlaceholderFormat(sq.Dollar)
+
+			if _, err := qExecTx(ctx, tx, updM); err != nil {
+				return err
+			}
+			if _, err := advanceFromMatchTx(ctx, tx, id, winner); err != nil {
+				return err
+			}
+			progressed = true
+		}
+		if !progressed {
+			return nil
+		}
+	}
+}
+
+// settleTournamentTx проводит bye, а когда сыграны все матчи — создаёт
+// следующий тур (круговая система, швейцарка) или завершает турнир.
+func settleTournamentTx(ctx context.Context, tx pgx.Tx, tournamentID int) error {
+	for {
+		if err := resolveWalkoversTx(ctx, tx, tournamentID); err != nil {
+			return err
+		}
+
+		pending := sq.Select("1").
+			From("matches").
+			Where(sq.Eq{"tournament_id": tournamentID}).
+			Where(sq.NotEq{"status": "finished"}).
+			PlaceholderFormat(sq.Dollar)
+
+		qPending := sq.Select().
+			Column(sq.Expr("EXISTS(?)", pending)).
+			PlaceholderFormat(sq.Dollar)
+
+		var has bool
+		if err := qRowTx(ctx, tx, qPending).Scan(&has); err != nil {
+			return err
+		}
+		if has {
+			return nil
+		}
+
+		var t roundConfig
+		qT := sq.Select("id", "mode", "format", "status", "current_round", "rounds", "created_by").
+			From("tournaments").
+			Where(sq.Eq{"id": tournamentID}).
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qT).Scan(&t.ID, &t.Mode, &t.Format, &t.Status, &t.Round, &t.Rounds, &t.CreatedBy); err != nil {
+			return err
+		}
+		if t.Status == "finished" {
+			return nil
+		}
+
+		var winner entrant
+		switch t.Format {
+		case "round_robin", "swiss":
+			more, err := nextRoundTx(ctx, tx, t)
+			if err != nil {
+				return err
+			}
+			if more {
+				continue
+			}
+			standings, err := tournamentStandingsTx(ctx, tx, tournamentID)
+			if err != nil {
+				return err
+			}
+			if len(standings) > 0 {
+				winner = standings[0].entrant()
+			}
+		default:
+			// финал — единственный матч сетки, из которого некуда идти дальше
+			var winnerUserID, winnerTeamID *int
+			qFinal := sq.Select("winner_user_id", "winner_team_id").
+				From("matches").
+				Where(sq.Eq{"tournament_id": tournamentID}).
+				Where(sq.Expr("next_match_id IS NULL")).
+				OrderBy("round DESC", "id DESC").
+				Limit(1).
+				PlaceholderFormat(sq.Dollar)
+
+			if err := qRowTx(ctx, tx, qFinal).Scan(&winnerUserID, &winnerTeamID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+			if winnerTeamID != nil {
+				winner = entrant{TeamID: *winnerTeamID}
+			} else if winnerUserID != nil {
+				winner = entrant{UserID: *winnerUserID}
+			}
+		}
+
+		upd := sq.Update("tournaments").
+			Set("status", "finished").
+			Set("winner_user_id", winner.userPtr()).
+			Set("winner_team_id", winner.teamPtr()).
+			Where(sq.Eq{"id": tournamentID}).
+			PlaceholderFormat(sq.Dollar)
+
+		_, err := qExecTx(ctx, tx, upd)
+		return err
+	}
+}
+
+// advanceTournamentTx вызывается при завершении любого матча.
+func advanceTournamentTx(ctx context.Context, tx pgx.Tx, matchID int, winner entrant) error {
+	tournamentID, err := advanceFromMatchTx(ctx, tx, matchID, winner)
+	if err != nil || tournamentID == 0 {
+		return err
+	}
+	return settleTournamentTx(ctx, tx, tournamentID)
+}
+
+// errTournamentAdvanced — результат матча уже повлиял на сыгранные
+// (или идущие) матчи турнира, и откатить его нельзя.
+var errTournamentAdvanced = errors.New("tournament already advanced")
+
+// undoAdvanceTx откатывает то, что сделало завершение матча в турнире:
+// убирает его участников из следующих матчей сетки или удаляет туры,
+// созданные после него (круговая система, швейцарка), и снова открывает
+// турнир. Если следующие матчи уже начались — errTournamentAdvanced.
+func undoAdvanceTx(ctx context.Context, tx pgx.Tx, matchID int) error {
+	var tournamentID, round, next, nextLoser *int
+	q := sq.Select("tournament_id", "round", "next_match_id", "next_loser_match_id").
+		From("matches").
+		Where(sq.Eq{"id": matchID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if err := qRowTx(ctx, tx, q).Scan(&tournamentID, &round, &next, &nextLoser); err != nil {
+		return err
+	}
+	if tournamentID == nil {
+		return nil
+	}
+
+	entrants, err := matchEntrantsTx(ctx, tx, matchID)
+	if err != nil {
+		return err
+	}
+	if len(entrants) < 2 {
+		return errTournamentAdvanced // bye переигрывать нечего
+	}
+
+	targets := []int{}
+	if next != nil {
+		targets = append(targets, *next)
+	}
+	if nextLoser != nil {
+		targets = append(targets, *nextLoser)
+	}
+
+	var later []int
+	if len(targets) == 0 && round != nil {
+		// круговая система / швейцарка: туры, созданные после этого матча
+		qL := sq.Select("id").
+			From("matches").
+			Where(sq.Eq{"tournament_id": *tournamentID}).
+			Where(sq.Gt{"round": *round}).
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQueryTx(ctx, tx, qL)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			later = append(later, id)
+		}
+		rows.Close()
+	}
+
+	// начавшийся матч (или сыгранный не как bye) не трогаем
+	for _, id := range append(append([]int{}, targets...), later...) {
+		var status string
+		qS := sq.Select("status").From("matches").Where(sq.Eq{"id": id}).Suffix("FOR UPDATE").PlaceholderFormat(sq.Dollar)
+		if err := qRowTx(ctx, tx, qS).Scan(&status); err != nil {
+			return err
+		}
+		if status == "closed" {
+			return errTournamentAdvanced
+		}
+		if status == "finished" {
+			others, err := matchEntrantsTx(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if len(others) > 1 {
+				return errTournamentAdvanced
+			}
+			if len(targets) > 0 {
+				return errTournamentAdvanced // bye уже провёл участника дальше по сетке
+			}
+		}
+	}
+
+	if len(targets) > 0 {
+		userIDs, teamIDs := []int{}, []int{}
+		for _, e := range entrants {
+			if e.TeamID > 0 {
+				teamIDs = append(teamIDs, e.TeamID)
+			} else {
+				userIDs = append(userIDs, e.UserID)
+			}
+		}
+
+		del := sq.Delete("match_participants").
+			Where(sq.Eq{"match_id": targets}).
+			Where(sq.Or{
+				sq.Eq{"team_id": teamIDs},
+				sq.And{sq.Eq{"team_id": nil}, sq.Eq{"user_id": userIDs}},
+			}).
+			PlaceholderFormat(sq.Dollar)
+
+		if _, err := qExecTx(ctx, tx, del); err != nil {
+			return err
+		}
+	}
+
+	if len(later) > 0 {
+		del := sq.Delete("matches").Where(sq.Eq{"id": later}).PlaceholderFormat(sq.Dollar)
+		if _, err := qExecTx(ctx, tx, del); err != nil {
+			return err
+		}
+	}
+
+	updT := sq.Update("tournaments").
+		Set("status", "running").
+		Set("winner_user_id", nil).
+		Set("winner_team_id", nil).
+		Where(sq.Eq{"id": *tournamentID}).
+		PlaceholderFormat(sq.Dollar)
+
+	if round != nil && len(targets) == 0 {
+		updT = updT.Set("current_round", sq.Expr("LEAST(current_round, ?)", *round))
+	}
+
+	_, err = qExecTx(ctx, tx, updT)
+	return err
+}
+
+/* ===================== TOURNAMENTS (USER) ===================== */
+
+// GET /api/tournaments
+func ListTournaments(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.Background()
+
+		q := sq.Select("id", "title", "mode", "format", "status", "winner_user_id", "winner_team_id").
+			From("tournaments").
+			OrderBy("id DESC").
+			Limit(200).
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, q)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rows.Close()
+
+		out := []Tournament{}
+		for rows.Next() {
+			var t Tournament
+			if err := rows.Scan(&t.ID, &t.Title, &t.Mode, &t.Format, &t.Status, &t.WinnerUserID, &t.WinnerTeamID); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			out = append(out, t)
+		}
+		c.JSON(200, out)
+	}
+}
+
+// GET /api/tournaments/:id/bracket
+// Сетка по раундам: upper (или единственная сетка single elimination), lower, final;
+// для круговой системы и швейцарки — туры (bracket = "rounds").
+func TournamentBracket(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный турнир")
+			return
+		}
+
+		ctx := context.Background()
+
+		var t Tournament
+		qT := sq.Select("id", "title", "mode", "format", "status", "winner_user_id", "winner_team_id").
+			From("tournaments").
+			Where(sq.Eq{"id": id}).
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRow(ctx, db, qT).Scan(&t.ID, &t.Title, &t.Mode, &t.Format, &t.Status, &t.WinnerUserID, &t.WinnerTeamID); err != nil {
+			jsonErr(c, 404, "Турнир не найден")
+			return
+		}
+
+		type E struct {
+			UserID *int   `json:"user_id,omitempty"`
+			TeamID *int   `json:"team_id,omitempty"`
+			Name   string `json:"name"`
+		}
+		type M struct {
+			ID               int    `json:"id"`
+			Title            string `json:"title"`
+			Status           string `json:"status"`
+			Position         int    `json:"position"`
+			Entrants         []E    `json:"entrants"`
+			WinnerUserID     *int   `json:"winner_user_id,omitempty"`
+			WinnerTeamID     *int   `json:"winner_team_id,omitempty"`
+			NextMatchID      *int   `json:"next_match_id,omitempty"`
+			NextLoserMatchID *int   `json:"next_loser_match_id,omitempty"`
+		}
+		type R struct {
+			Bracket string `json:"bracket"`
+			Round   int    `json:"round"`
+			Matches []*M   `json:"matches"`
+		}
+
+		qM := sq.Select("id", "title", "status", "COALESCE(bracket,'rounds')", "COALESCE(round,1)", "COALESCE(position,0)",
+			"winner_user_id", "winner_team_id", "next_match_id", "next_loser_match_id").
+			From("matches").
+			Where(sq.Eq{"tournament_id": id}).
+			OrderBy("CASE bracket WHEN 'upper' THEN 0 WHEN 'lower' THEN 1 ELSE 2 END", "round", "position").
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := qQuery(ctx, db, qM)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		rounds := []*R{}
+		byID := map[int]*M{}
+		for rows.Next() {
+			m := &M{Entrants: []E{}}
+			var bracket string
+			var round int
+			if err := rows.Scan(&m.ID, &m.Title, &m.Status, &bracket, &round, &m.Position,
+				&m.WinnerUserID, &m.WinnerTeamID, &m.NextMatchID, &m.NextLoserMatchID); err != nil {
+				rows.Close()
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			if len(rounds) == 0 || rounds[len(rounds)-1].Bracket != bracket || rounds[len(rounds)-1].Round != round {
+				rounds = append(rounds, &R{Bracket: bracket, Round: round, Matches: []*M{}})
+			}
+			r := rounds[len(rounds)-1]
+			r.Matches = append(r.Matches, m)
+			byID[m.ID] = m
+		}
+		rows.Close()
+
+		qP := sq.Select("mp.match_id", "CASE WHEN mp.team_id IS NULL THEN mp.user_id END", "mp.team_id",
+			"COALESCE(t.name, u.username)").
+			Distinct().
+			From("match_participants mp").
+			Join("matches m ON m.id = mp.match_id").
+			Join("users u ON u.id = mp.user_id").
+			LeftJoin("teams t ON t.id = mp.team_id").
+			Where(sq.Eq{"m.tournament_id": id}).
+			OrderBy("mp.match_id").
+			PlaceholderFormat(sq.Dollar)
+
+		rowsP, err := qQuery(ctx, db, qP)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer rowsP.Close()
+
+		for rowsP.Next() {
+			var mid int
+			var e E
+			if err := rowsP.Scan(&mid, &e.UserID, &e.TeamID, &e.Name); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+			if m, ok := byID[mid]; ok {
+				m.Entrants = append(m.Entrants, e)
+			}
+		}
+
+		c.JSON(200, gin.H{"tournament": t, "rounds": rounds})
+	}
+}
+
+/* ===================== ADMIN: TOURNAMENTS ===================== */
+
+// POST /api/admin/tournaments
+// { "title": "...", "mode": "solo|team", "format": "...", "entrants": [id, ...], "rounds": 5 }
+// format: single_elim|double_elim|round_robin|swiss.
+// entrants — id пользователей (solo) или команд (team) в порядке посева;
+// rounds — число туров швейцарки (по умолчанию log2 от числа участников).
+func AdminCreateTournament(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+
+		var req struct {
+			Title    string `json:"title"`
+			Mode     string `json:"mode"`
+			Format   string `json:"format"`
+			Entrants []int  `json:"entrants"`
+			Rounds   int    `json:"rounds"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		req.Title = clampRunes(req.Title, MaxTournamentTitle)
+		req.Mode = normMode(req.Mode)
+		req.Format = normFormat(req.Format)
+		if req.Title == "" || req.Mode == "invalid" || req.Format == "invalid" {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+
+		minEntrants := 2
+		if req.Format == "double_elim" {
+			minEntrants = 3
+		}
+		if len(req.Entrants) < minEntrants || len(req.Entrants) > MaxTournamentEntrants {
+			jsonErr(c, 400, "Некорректное число участников")
+			return
+		}
+		var rounds *int
+		if req.Format == "swiss" {
+			n := req.Rounds
+			if n == 0 {
+				for 1<<n < len(req.Entrants) {
+					n++
+				}
+			}
+			if n < 1 || n >= len(req.Entrants) {
+				jsonErr(c, 400, "Некорректное число туров")
+				return
+			}
+			rounds = &n
+		}
+
+		seen := map[int]bool{}
+		for _, id := range req.Entrants {
+			if id <= 0 || seen[id] {
+				jsonErr(c, 400, "Участники турнира указаны некорректно")
+				return
+			}
+			seen[id] = true
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// все участники существуют (админов в турнир не берём)
+		var found int
+		if req.Mode == "solo" {
+			q := sq.Select("COUNT(*)").
+				From("users").
+				Where(sq.Eq{"id": req.Entrants}).
+				Where(sq.NotEq{"role": "admin"}).
+				PlaceholderFormat(sq.Dollar)
+			_ = qRowTx(ctx, tx, q).Scan(&found)
+		} else {
+			sub := sq.Select("1").From("team_members tm").Where(sq.Expr("tm.team_id = t.id"))
+			q := sq.Select("COUNT(*)").
+				From("teams t").
+				Where(sq.Eq{"t.id": req.Entrants}).
+				Where(sq.Expr("EXISTS(?)", sub)).
+				PlaceholderFormat(sq.Dollar)
+			_ = qRowTx(ctx, tx, q).Scan(&found)
+		}
+		if found != len(req.Entrants) {
+			jsonErr(c, 400, "Часть участников не найдена")
+			return
+		}
+
+		insT := sq.Insert("tournaments").
+			Columns("title", "mode", "format", "rounds", "created_by").
+			Values(req.Title, req.Mode, req.Format, rounds, actor).
+			Suffix("RETURNING id").
+			PlaceholderFormat(sq.Dollar)
+
+		var tournamentID int
+		if err := qRowTx(ctx, tx, insT).Scan(&tournamentID); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		entrants := make([]entrant, len(req.Entrants))
+		for i, id := range req.Entrants {
+			if req.Mode == "solo" {
+				entrants[i] = entrant{UserID: id}
+			} else {
+				entrants[i] = entrant{TeamID: id}
+			}
+
+			insE := sq.Insert("tournament_entries").
+				Columns("tournament_id", "user_id", "team_id", "seed").
+				Values(tournamentID, entrants[i].userPtr(), entrants[i].teamPtr(), i+1).
+				PlaceholderFormat(sq.Dollar)
+
+			if _, err := qExecTx(ctx, tx, insE); err != nil {
+				jsonErr(c, 500, "Ошибка сервера")
+				return
+			}
+		}
+
+		if req.Format == "round_robin" || req.Format == "swiss" {
+			// первый тур создаётся так же, как последующие
+			err = settleTournamentTx(ctx, tx, tournamentID)
+		} else {
+			err = createBracketTx(ctx, tx, tournamentID, req.Mode, req.Format, actor, entrants)
+		}
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_create_tournament", "Администратор создал турнир: "+clampRunes(req.Title, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true, "tournament_id": tournamentID})
+	}
+}
+
+// createBracketTx создаёт матчи сетки, рассаживает участников первого раунда
+// по посеву и сразу проводит «пустые» матчи (bye).
+func createBracketTx(ctx context.Context, tx pgx.Tx, tournamentID int, mode, format string, actor int, entrants []entrant) error {
+	size := 1
+	for size < len(entrants) {
+		size *= 2
+	}
+	upper, lower, final := planBracket(format, size)
+
+	all := []*bracketMatch{}
+	for _, r := range upper {
+		all = append(all, r...)
+	}
+	for _, r := range lower {
+		all = append(all, r...)
+	}
+	if final != nil {
+		all = append(all, final)
+	}
+
+	for _, bm := range all {
+		ins := sq.Insert("matches").
+			Columns("title", "mode", "status", "created_by", "tournament_id", "bracket", "round", "position", "season_id").
+			Values(bm.title(format, len(upper)), mode, "open", actor, tournamentID, bm.Bracket, bm.Round, bm.Position, sq.Expr(currentSeasonSQL)).
+			Suffix("RETURNING id").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, ins).Scan(&bm.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, bm := range all {
+		if bm.Next == nil && bm.NextLoser == nil {
+			continue
+		}
+		upd := sq.Update("matches").Where(sq.Eq{"id": bm.ID}).PlaceholderFormat(sq.Dollar)
+		if bm.Next != nil {
+			upd = upd.Set("next_match_id", bm.Next.ID)
+		}
+		if bm.NextLoser != nil {
+			upd = upd.Set("next_loser_match_id", bm.NextLoser.ID)
+		}
+		if _, err := qExecTx(ctx, tx, upd); err != nil {
+			return err
+		}
+	}
+
+	order := bracketSeedOrder(size)
+	for pos, seed := range order {
+		if seed > len(entrants) {
+			continue // bye
+		}
+		if err := addEntrantToMatchTx(ctx, tx, upper[0][pos/2].ID, entrants[seed-1], 0); err != nil {
+			return err
+		}
+	}
+
+	return settleTournamentTx(ctx, tx, tournamentID)
+}
+
+// DELETE /api/admin/tournaments/:id (матчи турнира удаляются вместе с ним)
+// Турнир с сыгранными матчами не удаляется: их начисления остались бы без
+// match_id, и отменить их по матчу было бы уже нельзя.
+func AdminDeleteTournament(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		id, _ := strconv.Atoi(c.Param("id"))
+		if id <= 0 {
+			jsonErr(c, 400, "Некорректный турнир")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var lockedID int
+		lock := sq.Select("id").
+			From("tournaments").
+			Where(sq.Eq{"id": id}).
+			Suffix("FOR UPDATE").
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, lock).Scan(&lockedID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				jsonErr(c, 404, "Турнир не найден")
+				return
+			}
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		var played bool
+		finished := sq.Select("1").
+			From("matches").
+			Where(sq.Eq{"tournament_id": id, "status": "finished"})
+		qPlayed := sq.Select().
+			Column(sq.Expr("EXISTS(?)", finished)).
+			PlaceholderFormat(sq.Dollar)
+
+		if err := qRowTx(ctx, tx, qPlayed).Scan(&played); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if played {
+			jsonErr(c, 400, "В турнире есть сыгранные матчи — сначала отмените их итоги")
+			return
+		}
+
+		del := sq.Delete("tournaments").
+			Where(sq.Eq{"id": id}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExecTx(ctx, tx, del)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 404, "Турнир не найден")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		logAction(db, &actor, "admin_delete_tournament", "Администратор удалил турнир")
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
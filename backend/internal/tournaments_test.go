@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBracketSeedOrder(t *testing.T) {
+	tests := []struct {
+		size int
+		want []int
+	}{
+		{1, []int{1}},
+		{2, []int{1, 2}},
+		{4, []int{1, 4, 2, 3}},
+		{8, []int{1, 8, 4, 5, 2, 7, 3, 6}},
+	}
+	for _, tt := range tests {
+		if got := bracketSeedOrder(tt.size); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bracketSeedOrder(%d) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+
+	// в каждой паре первого раунда сумма посевов size+1
+	for _, size := range []int{2, 4, 8, 16, 32, 64} {
+		order := bracketSeedOrder(size)
+		for i := 0; i < size; i += 2 {
+			if order[i]+order[i+1] != size+1 {
+				t.Errorf("size %d: pair %d-%d", size, order[i], order[i+1])
+			}
+		}
+	}
+}
+
+// bracketInputs — сколько участников приходит в каждый матч сетки из
+// предыдущих матчей (победители по Next, проигравшие по NextLoser).
+func bracketInputs(upper, lower [][]*bracketMatch) map[*bracketMatch]int {
+	in := map[*bracketMatch]int{}
+	for _, rounds := range [][][]*bracketMatch{upper, lower} {
+		for _, round := range rounds {
+			for _, bm := range round {
+				if bm.Next != nil {
+					in[bm.Next]++
+				}
+				if bm.NextLoser != nil {
+					in[bm.NextLoser]++
+				}
+			}
+		}
+	}
+	return in
+}
+
+func TestPlanBracketSingleElim(t *testing.T) {
+	upper, lower, final := planBracket("single_elim", 8)
+	if lower != nil || final != nil {
+		t.Fatalf("single_elim: unexpected lower bracket or grand final")
+	}
+	if len(upper) != 3 || len(upper[0]) != 4 || len(upper[1]) != 2 || len(upper[2]) != 1 {
+		t.Fatalf("single_elim 8: rounds %d", len(upper))
+	}
+	if upper[2][0].Next != nil {
+		t.Errorf("final has Next")
+	}
+
+	in := bracketInputs(upper, lower)
+	for r := 1; r < len(upper); r++ {
+		for _, bm := range upper[r] {
+			if in[bm] != 2 {
+				t.Errorf("R%d-%d: %d inputs, want 2", bm.Round, bm.Position+1, in[bm])
+			}
+		}
+	}
+	for _, bm := range upper[0] {
+		if bm.NextLoser != nil {
+			t.Errorf("R1-%d: loser goes on in single_elim", bm.Position+1)
+		}
+	}
+}
+
+func TestPlanBracketDoubleElim(t *testing.T) {
+	for _, size := range []int{4, 8, 16} {
+		upper, lower, final := planBracket("double_elim", size)
+		if final == nil {
+			t.Fatalf("size %d: no grand final", size)
+		}
+
+		k := len(upper)
+		if len(lower) != 2*(k-1) {
+			t.Errorf("size %d: %d lower rounds, want %d", size, len(lower), 2*(k-1))
+		}
+
+		// первый раунд верхней сетки заполняется посевом, остальные матчи —
+		// ровно двумя участниками из предыдущих
+		in := bracketInputs(upper, lower)
+		for r, round := range upper {
+			for _, bm := range round {
+				if bm.NextLoser == nil {
+					t.Errorf("size %d: W%d-%d: loser is not sent to the lower bracket", size, bm.Round, bm.Position+1)
+				}
+				if r > 0 && in[bm] != 2 {
+					t.Errorf("size %d: W%d-%d: %d inputs, want 2", size, bm.Round, bm.Position+1, in[bm])
+				}
+			}
+		}
+		for _, round := range lower {
+			for _, bm := range round {
+				if in[bm] != 2 {
+					t.Errorf("size %d: L%d-%d: %d inputs, want 2", size, bm.Round, bm.Position+1, in[bm])
+				}
+				if bm.NextLoser != nil {
+					t.Errorf("size %d: L%d-%d: loser of the lower bracket goes on", size, bm.Round, bm.Position+1)
+				}
+			}
+		}
+		if in[final] != 2 {
+			t.Errorf("size %d: grand final has %d inputs, want 2", size, in[final])
+		}
+		if upper[k-1][0].Next != final || lower[len(lower)-1][0].Next != final {
+			t.Errorf("size %d: bracket winners do not meet in the grand final", size)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// userStateTTL ограничивает устаревание кэша для изменений без явной
+// инвалидации (например, истечение сессии по времени).
+const userStateTTL = 30 * time.Second
+
+// userStateMax — после стольких записей устаревшие вычищаются.
+const userStateMax = 10_000
+
+// userState — то, что Auth знает о сессии: чья она, роль и бан владельца,
+// подтверждена ли сессия вторым фактором.
+type userState struct {
+	UserID int
+	Role   string
+	Banned bool
+	MFA    bool
+	at     time.Time
+}
+
+// userStates — кэш состояний по id сессии. Любое изменение пользователя
+// (роль, бан, удаление) или отзыв сессий должно сбрасывать его через
+// invalidateUser/invalidateSession.
+var userStates = struct {
+	sync.RWMutex
+	m map[int64]userState
+}{m: map[int64]userState{}}
+
+func invalidateUser(userID int) {
+	userStates.Lock()
+	defer userStates.Unlock()
+	for sid, st := range userStates.m {
+		if st.UserID == userID {
+			delete(userStates.m, sid)
+		}
+	}
+}
+
+func invalidateSession(sid int64) {
+	userStates.Lock()
+	delete(userStates.m, sid)
+	userStates.Unlock()
+}
+
+// loadUserState — состояние активной сессии из кэша или БД.
+// pgx.ErrNoRows — сессия отозвана, истекла или пользователь удалён.
+func loadUserState(ctx context.Context, db *pgxpool.Pool, sid int64, userID int) (userState, error) {
+	userStates.RLock()
+	st, ok := userStates.m[sid]
+	userStates.RUnlock()
+	if ok && st.UserID == userID && time.Since(st.at) < userStateTTL {
+		return st, nil
+	}
+
+	q := sq.Select("u.role", "u.banned_at IS NOT NULL", "s.mfa").
+		From("sessions s").
+		Join("users u ON u.id = s.user_id").
+		Where(sq.Eq{"s.id": sid, "s.user_id": userID}).
+		Where(sqlSessionActive).
+		PlaceholderFormat(sq.Dollar)
+
+	st = userState{UserID: userID, at: time.Now()}
+	if err := qRow(ctx, db, q).Scan(&st.Role, &st.Banned, &st.MFA); err != nil {
+		invalidateSession(sid)
+		return userState{}, err
+	}
+
+	userStates.Lock()
+	if len(userStates.m) >= userStateMax {
+		for k, v := range userStates.m {
+			if time.Since(v.at) >= userStateTTL {
+				delete(userStates.m, k)
+			}
+		}
+	}
+	userStates.m[sid] = st
+	userStates.Unlock()
+	return st, nil
+}
+
+/* ===================== ADMIN: BAN / ROLE ===================== */
+
+// lockOtherUserTx блокирует пользователя для изменения администратором
+// (не самого себя). Возвращает username.
+func lockOtherUserTx(ctx context.Context, c *gin.Context, tx pgx.Tx, actor, id int) (string, bool) {
+	if id <= 0 {
+		jsonErr(c, 400, "Некорректный пользователь")
+		return "", false
+	}
+	if id == actor {
+		jsonErr(c, 400, "Нельзя изменить самого себя")
+		return "", false
+	}
+
+	q := sq.Select("username").
+		From("users").
+		Where(sq.Eq{"id": id}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	var username string
+	if err := qRowTx(ctx, tx, q).Scan(&username); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			jsonErr(c, 404, "Пользователь не найден")
+			return "", false
+		}
+		jsonErr(c, 500, "Ошибка сервера")
+		return "", false
+	}
+	return username, true
+}
+
+// POST /api/admin/users/:id/ban  { "reason": "..." }
+// Бан действует сразу: все сессии отзываются, вход запрещён.
+func AdminBanUser(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		// тело необязательно, но битый JSON — ошибка
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if len([]rune(req.Reason)) > MaxBanReason {
+			jsonErr(c, 400, "Слишком длинная причина")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		username, ok := lockOtherUserTx(ctx, c, tx, actor, id)
+		if !ok {
+			return
+		}
+
+		upd := sq.Update("users").
+			Set("banned_at", sq.Expr(sqlNowUTC)).
+			Set("ban_reason", req.Reason).
+			Where(sq.Eq{"id": id, "banned_at": nil}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExecTx(ctx, tx, upd)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 400, "Пользователь уже заблокирован")
+			return
+		}
+
+		if err := revokeUserSessionsTx(ctx, tx, id); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		invalidateUser(id)
+
+		logAction(db, &actor, "admin_ban_user", "Администратор заблокировал: "+clampRunes(username, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// POST /api/admin/users/:id/unban
+func AdminUnbanUser(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		username, ok := lockOtherUserTx(ctx, c, tx, actor, id)
+		if !ok {
+			return
+		}
+
+		upd := sq.Update("users").
+			Set("banned_at", nil).
+			Set("ban_reason", "").
+			Where(sq.Eq{"id": id}).
+			Where(sq.NotEq{"banned_at": nil}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExecTx(ctx, tx, upd)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 400, "Пользователь не заблокирован")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		invalidateUser(id)
+
+		logAction(db, &actor, "admin_unban_user", "Администратор разблокировал: "+clampRunes(username, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// POST /api/admin/users/:id/role  { "role": "admin" | "user" }
+// Новая роль применяется к уже открытым сессиям сразу.
+func AdminSetRole(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := uid(c)
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			jsonErr(c, 400, "Некорректные данные")
+			return
+		}
+		req.Role = strings.ToLower(strings.TrimSpace(req.Role))
+		if req.Role != "admin" && req.Role != "user" {
+			jsonErr(c, 400, "Некорректная роль")
+			return
+		}
+
+		ctx := context.Background()
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		username, ok := lockOtherUserTx(ctx, c, tx, actor, id)
+		if !ok {
+			return
+		}
+
+		upd := sq.Update("users").
+			Set("role", req.Role).
+			Where(sq.Eq{"id": id}).
+			Where(sq.NotEq{"role": req.Role}).
+			PlaceholderFormat(sq.Dollar)
+
+		tag, err := qExecTx(ctx, tx, upd)
+		if err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			jsonErr(c, 400, "Роль уже назначена")
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			jsonErr(c, 500, "Ошибка сервера")
+			return
+		}
+		invalidateUser(id)
+
+		logAction(db, &actor, "admin_set_role", "Роль "+req.Role+": "+clampRunes(username, MaxReportLine))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
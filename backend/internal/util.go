@@ -2,9 +2,11 @@ package internal
 
 import (
 	"context"
+	"strconv"
 	"strings"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -66,6 +68,24 @@ func qExecTx(ctx context.Context, tx pgx.Tx, q sq.Sqlizer) (pgconn.CommandTag, e
 	return tx.Exec(ctx, sql, args...)
 }
 
+/* ===================== PAGINATION ===================== */
+
+// pageParams читает ?limit=&offset= (limit по умолчанию DefaultPage, не больше MaxPage).
+func pageParams(c *gin.Context) (uint64, uint64) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	if limit <= 0 {
+		limit = DefaultPage
+	}
+	if limit > MaxPage {
+		limit = MaxPage
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return uint64(limit), uint64(offset)
+}
+
 /* ===================== LOGGING ===================== */
 
 func logAction(db *pgxpool.Pool, actorID *int, action, details string) {
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 
 	"ctf-platform/internal"
 
@@ -26,8 +27,26 @@ func main() {
 	db := internal.MustDB(dbURL)
 	defer db.Close()
 
+	// open -> closed -> finished по starts_at/ends_at
+	go internal.RunScheduler(db)
+
 	r := gin.Default()
 
+	// c.ClientIP() — ключ счётчика неудачных входов: X-Forwarded-For
+	// принимается только от перечисленных прокси (TRUSTED_PROXIES через
+	// запятую), по умолчанию — ни от кого
+	var proxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				proxies = append(proxies, p)
+			}
+		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
 	// Frontend static
 	r.Static("/static", "/app/static")
 	r.GET("/", func(c *gin.Context) { c.File("/app/static/index.html") })
@@ -39,51 +58,123 @@ func main() {
 	{
 		api.POST("/auth/register", internal.Register(db))
 		api.POST("/auth/login", internal.Login(db, secret))
-		api.POST("/auth/logout", internal.Logout())
-		api.GET("/me", internal.Auth(secret), internal.Me(db))
-
-		api.GET("/rating", internal.Auth(secret), internal.Rating(db))
+		api.POST("/auth/logout", internal.Logout(db))
+		api.POST("/auth/refresh", internal.Refresh(db, secret))
+		api.POST("/auth/logout-all", internal.Auth(db, secret), internal.LogoutAll(db))
+		api.POST("/auth/reset-password", internal.ResetPassword(db))
+		api.POST("/me/password", internal.Auth(db, secret), internal.ChangePassword(db, secret))
+
+		// 2FA (TOTP); для администраторов обязательна
+		api.POST("/auth/login/2fa", internal.LoginSecondFactor(db, secret))
+		api.GET("/me/2fa", internal.Auth(db, secret), internal.TwoFactorStatus(db))
+		api.POST("/me/2fa/setup", internal.Auth(db, secret), internal.TwoFactorSetup(db))
+		api.POST("/me/2fa/enable", internal.Auth(db, secret), internal.TwoFactorEnable(db))
+		api.POST("/me/2fa/disable", internal.Auth(db, secret), internal.TwoFactorDisable(db))
+		api.GET("/my/sessions", internal.Auth(db, secret), internal.MySessions(db))
+		api.DELETE("/my/sessions/:sid", internal.Auth(db, secret), internal.RevokeSession(db))
+		api.GET("/me", internal.Auth(db, secret), internal.Me(db))
+
+		api.GET("/rating", internal.Auth(db, secret), internal.Rating(db))
+		api.GET("/rating/teams", internal.Auth(db, secret), internal.RatingTeams(db))
+
+		// seasons
+		api.GET("/seasons", internal.Auth(db, secret), internal.ListSeasons(db))
+		api.GET("/seasons/current", internal.Auth(db, secret), internal.CurrentSeason(db))
+		api.GET("/seasons/:id/standings", internal.Auth(db, secret), internal.SeasonStandings(db))
 
 		// ✅ users search (for owner closed-team add)
-		api.GET("/users/search", internal.Auth(secret), internal.SearchUsers(db))
-
-		// matches/applications/history (status: open|finished|all)
-		api.GET("/matches", internal.Auth(secret), internal.ListMatches(db))
-		api.POST("/matches/:id/apply", internal.Auth(secret), internal.ApplyToMatch(db))
-		api.GET("/my/applications", internal.Auth(secret), internal.MyApplications(db))
-		api.GET("/history", internal.Auth(secret), internal.MyHistory(db))
+		api.GET("/users/search", internal.Auth(db, secret), internal.SearchUsers(db))
+
+		// matches/applications/history (status: open|closed|finished|all)
+		api.GET("/matches", internal.Auth(db, secret), internal.ListMatches(db))
+		api.POST("/matches/:id/apply", internal.Auth(db, secret), internal.ApplyToMatch(db))
+		api.POST("/matches/:id/withdraw", internal.Auth(db, secret), internal.WithdrawApplication(db))
+		api.GET("/my/applications", internal.Auth(db, secret), internal.MyApplications(db)) // ?detailed=1 — с местом в листе ожидания
+		api.GET("/history", internal.Auth(db, secret), internal.MyHistory(db))
+		api.GET("/my/points", internal.Auth(db, secret), internal.MyPoints(db))
+
+		// challenges (jeopardy)
+		api.GET("/matches/:id/challenges", internal.Auth(db, secret), internal.ListChallenges(db))
+		api.POST("/matches/:id/challenges/:cid/submit", internal.Auth(db, secret), internal.SubmitFlag(db))
+		api.GET("/matches/:id/scoreboard", internal.Auth(db, secret), internal.MatchScoreboard(db))
+
+		// tournaments
+		api.GET("/tournaments", internal.Auth(db, secret), internal.ListTournaments(db))
+		api.GET("/tournaments/:id/bracket", internal.Auth(db, secret), internal.TournamentBracket(db))
+		api.GET("/tournaments/:id/standings", internal.Auth(db, secret), internal.TournamentStandings(db))
 
 		// teams
-		api.POST("/teams", internal.Auth(secret), internal.CreateTeam(db))
-		api.GET("/teams/open", internal.Auth(secret), internal.ListOpenTeams(db))
-		api.GET("/my/teams", internal.Auth(secret), internal.MyTeams(db))
-		api.POST("/teams/:id/join", internal.Auth(secret), internal.JoinTeam(db))
-		api.POST("/teams/:id/leave", internal.Auth(secret), internal.LeaveTeam(db))
-
-		// ✅ owner закрытой команды добавляет участников
-		api.POST("/teams/:id/add-user", internal.Auth(secret), internal.OwnerAddUserToClosedTeam(db))
+		api.POST("/teams", internal.Auth(db, secret), internal.CreateTeam(db))
+		api.GET("/teams/open", internal.Auth(db, secret), internal.ListOpenTeams(db))
+		api.GET("/my/teams", internal.Auth(db, secret), internal.MyTeams(db))
+		api.POST("/teams/:id/join", internal.Auth(db, secret), internal.JoinTeam(db))
+		api.POST("/teams/:id/leave", internal.Auth(db, secret), internal.LeaveTeam(db))
+		api.POST("/teams/:id/transfer", internal.Auth(db, secret), internal.TransferTeam(db))
+		api.POST("/teams/:id/captain", internal.Auth(db, secret), internal.SetTeamCaptain(db))
+		api.DELETE("/teams/:id", internal.Auth(db, secret), internal.DisbandTeam(db))
+		api.PUT("/teams/:id", internal.Auth(db, secret), internal.UpdateTeam(db))
+		api.POST("/teams/:id/kick", internal.Auth(db, secret), internal.KickTeamMember(db))
+
+		// ✅ owner закрытой команды приглашает участников
+		api.POST("/teams/:id/add-user", internal.Auth(db, secret), internal.OwnerInviteToClosedTeam(db))
+		api.GET("/my/invites", internal.Auth(db, secret), internal.MyInvites(db))
+		api.POST("/invites/:id/accept", internal.Auth(db, secret), internal.AcceptInvite(db))
+		api.POST("/invites/:id/decline", internal.Auth(db, secret), internal.DeclineInvite(db))
+
+		// заявки в закрытые команды
+		api.POST("/teams/:id/request", internal.Auth(db, secret), internal.RequestToJoinTeam(db))
+		api.GET("/my/join-requests", internal.Auth(db, secret), internal.MyJoinRequests(db))
+		api.GET("/teams/:id/requests", internal.Auth(db, secret), internal.TeamJoinRequests(db))
+		api.POST("/teams/:id/requests/:rid/approve", internal.Auth(db, secret), internal.ApproveJoinRequest(db))
+		api.POST("/teams/:id/requests/:rid/reject", internal.Auth(db, secret), internal.RejectJoinRequest(db))
 
 		// admin
-		admin := api.Group("/admin", internal.Auth(secret), internal.RequireAdmin())
+		admin := api.Group("/admin", internal.Auth(db, secret), internal.RequireAdmin(), internal.RequireMFA())
 		{
 			admin.GET("/logs", internal.AdminLogs(db))
+			admin.GET("/lockouts", internal.AdminLockouts(db))    // ?all=1
+			admin.DELETE("/lockouts", internal.AdminClearLockout(db)) // ?kind=user|ip&key=
 			admin.GET("/users", internal.AdminUsers(db))
 			admin.DELETE("/users/:id", internal.AdminDeleteUser(db))
+			admin.POST("/users/:id/ban", internal.AdminBanUser(db))
+			admin.POST("/users/:id/unban", internal.AdminUnbanUser(db))
+			admin.POST("/users/:id/role", internal.AdminSetRole(db))
+			admin.POST("/users/:id/reset-token", internal.AdminPasswordResetToken(db))
 			admin.POST("/users/:id/points", internal.AdminSetPoints(db))
+			admin.GET("/users/:id/points", internal.AdminUserPoints(db))
+			admin.POST("/points/:tid/revert", internal.AdminRevertPoints(db))
 
 			admin.POST("/matches", internal.AdminCreateMatch(db))
 			admin.PUT("/matches/:id", internal.AdminUpdateMatch(db))
 			admin.DELETE("/matches/:id", internal.AdminDeleteMatch(db))
+			admin.POST("/matches/:id/status", internal.AdminSetMatchStatus(db))                // open <-> closed
 
 			admin.GET("/applications", internal.AdminListApplications(db))
 			admin.POST("/applications/:id/approve", internal.AdminApproveApplication(db))
 			admin.POST("/applications/:id/reject", internal.AdminRejectApplication(db))
 
 			admin.POST("/matches/:id/winner", internal.AdminSetWinner(db))                       // finish match
-			admin.GET("/matches", internal.AdminListMatches(db))                                // ?status=open|finished|all
-			admin.GET("/matches/:id/participants", internal.AdminMatchParticipants(db))         // only for open
+			admin.POST("/matches/:id/finalize", internal.AdminFinalizeMatch(db))                // finish by scoreboard
+			admin.POST("/matches/:id/reopen", internal.AdminReopenMatch(db))                    // finished -> closed
+			admin.GET("/matches", internal.AdminListMatches(db))                                // ?status=open|closed|finished|all
+			admin.GET("/matches/:id/participants", internal.AdminMatchParticipants(db))         // open|closed
 			admin.GET("/matches/:id/report", internal.AdminMatchReport(db))
 
+			admin.GET("/matches/:id/invites", internal.AdminListMatchInvites(db))
+			admin.POST("/matches/:id/invites", internal.AdminAddMatchInvite(db))
+			admin.DELETE("/matches/:id/invites/:iid", internal.AdminDeleteMatchInvite(db))
+
+			admin.GET("/matches/:id/challenges", internal.AdminListChallenges(db))
+			admin.POST("/matches/:id/challenges", internal.AdminCreateChallenge(db))
+			admin.PUT("/matches/:id/challenges/:cid", internal.AdminUpdateChallenge(db))
+			admin.DELETE("/matches/:id/challenges/:cid", internal.AdminDeleteChallenge(db))
+
+			admin.POST("/tournaments", internal.AdminCreateTournament(db))
+			admin.DELETE("/tournaments/:id", internal.AdminDeleteTournament(db))
+
+			admin.POST("/seasons", internal.AdminOpenSeason(db))
+			admin.POST("/seasons/:id/close", internal.AdminCloseSeason(db))
+
 			admin.GET("/teams", internal.AdminListTeams(db))
 
 			admin.GET("/teams/:id/members", internal.AdminTeamMembers(db))
@@ -3,10 +3,76 @@ CREATE TABLE IF NOT EXISTS users (
   username     TEXT UNIQUE NOT NULL,
   pass_hash    TEXT NOT NULL,
   role         TEXT NOT NULL CHECK (role IN ('admin','user')),
-  points       INT NOT NULL DEFAULT 0,
+  points       INT NOT NULL DEFAULT 0,     -- очки достижений, кэш суммы points_transactions
+  rating       INT NOT NULL DEFAULT 1500,  -- Elo, пересчитывается по итогам матчей
+  banned_at    TIMESTAMP NULL,
+  ban_reason   TEXT NOT NULL DEFAULT '',
+  totp_secret  TEXT NULL,                   -- base32, RFC 6238
+  totp_enabled BOOLEAN NOT NULL DEFAULT false,
+  totp_last_step BIGINT NOT NULL DEFAULT 0, -- последний принятый шаг: код не принимается дважды
   created_at   TIMESTAMP NOT NULL DEFAULT now()
 );
 
+-- сессии входа: refresh-токен хранится хэшем и меняется при каждом обновлении,
+-- prev_hash ловит повторное использование старого токена
+CREATE TABLE IF NOT EXISTS sessions (
+  id           BIGSERIAL PRIMARY KEY,
+  user_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  refresh_hash TEXT NOT NULL UNIQUE,
+  prev_hash    TEXT NULL,
+  user_agent   TEXT NOT NULL DEFAULT '',
+  ip           TEXT NOT NULL DEFAULT '',
+  created_at   TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+  last_used_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+  expires_at   TIMESTAMP NOT NULL,
+  revoked_at   TIMESTAMP NULL,
+  mfa          BOOLEAN NOT NULL DEFAULT false -- вход подтверждён вторым фактором
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS sessions_prev_idx ON sessions(prev_hash);
+
+-- одноразовые токены сброса пароля, выданные администратором (хранится хэш)
+CREATE TABLE IF NOT EXISTS password_resets (
+  id          BIGSERIAL PRIMARY KEY,
+  user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash  TEXT NOT NULL UNIQUE,
+  created_by  INT NULL REFERENCES users(id) ON DELETE SET NULL,
+  created_at  TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+  expires_at  TIMESTAMP NOT NULL,
+  used_at     TIMESTAMP NULL
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets(user_id);
+
+-- коды восстановления 2FA (хэши), каждый одноразовый
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id         BIGSERIAL PRIMARY KEY,
+  user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash  TEXT NOT NULL,
+  used_at    TIMESTAMP NULL,
+  UNIQUE(user_id, code_hash)
+);
+
+-- второй шаг входа: токен после проверки пароля, ждёт код 2FA
+CREATE TABLE IF NOT EXISTS login_challenges (
+  id          BIGSERIAL PRIMARY KEY,
+  user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash  TEXT NOT NULL UNIQUE,
+  attempts    INT NOT NULL DEFAULT 0,
+  expires_at  TIMESTAMP NOT NULL
+);
+
+-- неудачные входы по логину (в нижнем регистре) и по IP: пауза и блокировка
+CREATE TABLE IF NOT EXISTS login_attempts (
+  kind           TEXT NOT NULL CHECK (kind IN ('user','ip')),
+  key            TEXT NOT NULL,
+  failures       INT NOT NULL DEFAULT 0,
+  last_failed_at TIMESTAMP NOT NULL,
+  locked_until   TIMESTAMP NULL,
+  PRIMARY KEY (kind, key)
+);
+
 CREATE TABLE IF NOT EXISTS logs (
   id          BIGSERIAL PRIMARY KEY,
   created_at  TIMESTAMP NOT NULL DEFAULT now(),
@@ -15,6 +81,45 @@ CREATE TABLE IF NOT EXISTS logs (
   details     TEXT NOT NULL DEFAULT ''
 );
 
+CREATE TABLE IF NOT EXISTS seasons (
+  id         SERIAL PRIMARY KEY,
+  title      TEXT NOT NULL,
+  status     TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open','closed')),
+  reset      TEXT NOT NULL CHECK (reset IN ('zero','soft')),
+  created_by INT NULL REFERENCES users(id) ON DELETE SET NULL,
+  started_at TIMESTAMP NOT NULL DEFAULT now(),
+  ended_at   TIMESTAMP NULL
+);
+
+-- одновременно идёт не больше одного сезона
+CREATE UNIQUE INDEX IF NOT EXISTS seasons_open_uniq ON seasons((status)) WHERE status = 'open';
+
+-- итоговая таблица закрытого сезона
+CREATE TABLE IF NOT EXISTS season_standings (
+  season_id INT NOT NULL REFERENCES seasons(id) ON DELETE CASCADE,
+  user_id   INT NULL REFERENCES users(id) ON DELETE SET NULL,
+  username  TEXT NOT NULL,
+  rank      INT NOT NULL,
+  points    INT NOT NULL,
+  rating    INT NOT NULL,
+  played    INT NOT NULL,
+  PRIMARY KEY(season_id, rank)
+);
+
+CREATE TABLE IF NOT EXISTS tournaments (
+  id             SERIAL PRIMARY KEY,
+  title          TEXT NOT NULL,
+  mode           TEXT NOT NULL CHECK (mode IN ('solo','team')),
+  format         TEXT NOT NULL CHECK (format IN ('single_elim','double_elim','round_robin','swiss')),
+  status         TEXT NOT NULL DEFAULT 'running' CHECK (status IN ('running','finished')),
+  rounds         INT NULL,                 -- число туров швейцарки
+  current_round  INT NOT NULL DEFAULT 0,   -- последний созданный тур (round_robin/swiss)
+  created_by     INT NOT NULL REFERENCES users(id),
+  winner_user_id INT NULL REFERENCES users(id),
+  winner_team_id INT NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT now()
+);
+
 CREATE TABLE IF NOT EXISTS matches (
   id             SERIAL PRIMARY KEY,
   title          TEXT NOT NULL,
@@ -25,31 +130,110 @@ CREATE TABLE IF NOT EXISTS matches (
   created_by     INT NOT NULL REFERENCES users(id),
   winner_user_id INT NULL REFERENCES users(id),
   winner_team_id INT NULL,
+  -- сетка турнира: куда уходят победитель и (в double elimination) проигравший
+  tournament_id       INT NULL REFERENCES tournaments(id) ON DELETE CASCADE,
+  bracket             TEXT NULL CHECK (bracket IN ('upper','lower','final')),
+  round               INT NULL,
+  position            INT NULL,
+  next_match_id       INT NULL REFERENCES matches(id) ON DELETE SET NULL,
+  next_loser_match_id INT NULL REFERENCES matches(id) ON DELETE SET NULL,
+  season_id           INT NULL REFERENCES seasons(id) ON DELETE SET NULL,
+  -- ограничения на участие
+  min_team_size       INT NOT NULL DEFAULT 1,
+  max_team_size       INT NOT NULL DEFAULT 5,
+  max_participants    INT NOT NULL DEFAULT 0,      -- 0 = без лимита
+  min_rating          INT NOT NULL DEFAULT 0,
+  invite_only         BOOLEAN NOT NULL DEFAULT FALSE,
+  allow_solo          BOOLEAN NOT NULL DEFAULT FALSE, -- одиночки в командном матче
   created_at     TIMESTAMP NOT NULL DEFAULT now()
 );
 
+CREATE INDEX IF NOT EXISTS matches_tournament_idx ON matches(tournament_id);
+CREATE INDEX IF NOT EXISTS matches_season_idx ON matches(season_id);
+
 CREATE TABLE IF NOT EXISTS teams (
   id         SERIAL PRIMARY KEY,
   name       TEXT NOT NULL,
   owner_id   INT NOT NULL REFERENCES users(id),
   is_open    BOOLEAN NOT NULL DEFAULT TRUE,
-  created_at TIMESTAMP NOT NULL DEFAULT now()
+  rating     INT NOT NULL DEFAULT 1500,  -- Elo команды, не зависит от состава
+  wins       INT NOT NULL DEFAULT 0,
+  points     INT NOT NULL DEFAULT 0,     -- очки, заработанные в командных матчах
+  created_at TIMESTAMP NOT NULL DEFAULT now(),
+  disbanded_at TIMESTAMP NULL            -- UTC; строка остаётся ради истории матчей
 );
 
 CREATE TABLE IF NOT EXISTS team_members (
-  team_id INT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
-  user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  team_id    INT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  is_captain BOOLEAN NOT NULL DEFAULT FALSE, -- может подавать заявки от команды
+  joined_at  TIMESTAMP NOT NULL DEFAULT now(),
   PRIMARY KEY(team_id, user_id)
 );
 
+CREATE TABLE IF NOT EXISTS team_invites (
+  id         SERIAL PRIMARY KEY,
+  team_id    INT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  invited_by INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  status     TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','accepted','declined','expired')),
+  created_at TIMESTAMP NOT NULL DEFAULT now(),
+  expires_at TIMESTAMP NOT NULL  -- UTC
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS team_invites_pending_uniq ON team_invites(team_id, user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS team_invites_user_idx ON team_invites(user_id);
+
+CREATE TABLE IF NOT EXISTS team_join_requests (
+  id         SERIAL PRIMARY KEY,
+  team_id    INT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  status     TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','approved','rejected')),
+  decided_by INT NULL REFERENCES users(id) ON DELETE SET NULL,
+  decided_at TIMESTAMP NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS team_join_requests_pending_uniq ON team_join_requests(team_id, user_id) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS tournament_entries (
+  id            SERIAL PRIMARY KEY,
+  tournament_id INT NOT NULL REFERENCES tournaments(id) ON DELETE CASCADE,
+  user_id       INT NULL REFERENCES users(id) ON DELETE CASCADE,
+  team_id       INT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  seed          INT NOT NULL,
+  UNIQUE(tournament_id, seed)
+);
+
 CREATE TABLE IF NOT EXISTS applications (
   id         SERIAL PRIMARY KEY,
   match_id   INT NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
   user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
   team_id    INT NULL REFERENCES teams(id) ON DELETE SET NULL,
-  status     TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','approved','rejected')),
+  status     TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','approved','rejected','waitlisted','withdrawn')),
+  created_at TIMESTAMP NOT NULL DEFAULT now(),
+  UNIQUE(match_id, user_id),
+  UNIQUE(match_id, team_id)
+);
+
+-- приглашения на матчи с invite_only
+CREATE TABLE IF NOT EXISTS match_invites (
+  id         SERIAL PRIMARY KEY,
+  match_id   INT NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+  user_id    INT NULL REFERENCES users(id) ON DELETE CASCADE,
+  team_id    INT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  invited_by INT NULL REFERENCES users(id) ON DELETE SET NULL,
   created_at TIMESTAMP NOT NULL DEFAULT now(),
-  UNIQUE(match_id, user_id)
+  CHECK ((user_id IS NULL) <> (team_id IS NULL)),
+  UNIQUE(match_id, user_id),
+  UNIQUE(match_id, team_id)
+);
+
+-- состав команды, зафиксированный при подаче заявки
+CREATE TABLE IF NOT EXISTS application_roster (
+  application_id INT NOT NULL REFERENCES applications(id) ON DELETE CASCADE,
+  user_id        INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  PRIMARY KEY(application_id, user_id)
 );
 
 CREATE TABLE IF NOT EXISTS match_participants (
@@ -59,6 +243,83 @@ CREATE TABLE IF NOT EXISTS match_participants (
   PRIMARY KEY(match_id, user_id)
 );
 
+CREATE TABLE IF NOT EXISTS challenges (
+  id          SERIAL PRIMARY KEY,
+  match_id    INT NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+  name        TEXT NOT NULL,
+  category    TEXT NOT NULL DEFAULT '',
+  description TEXT NOT NULL DEFAULT '',
+  points      INT NOT NULL CHECK (points > 0),      -- начальная стоимость
+  scoring     TEXT NOT NULL DEFAULT 'static' CHECK (scoring IN ('static','dynamic')),
+  minimum     INT NOT NULL DEFAULT 0 CHECK (minimum >= 0),
+  decay       INT NOT NULL DEFAULT 0 CHECK (decay >= 0), -- за сколько решений стоимость падает до minimum
+  flag        TEXT NOT NULL,
+  created_at  TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS challenges_match_idx ON challenges(match_id);
+
+-- решения: в team-матче засчитываются команде, в solo — пользователю
+CREATE TABLE IF NOT EXISTS solves (
+  id           BIGSERIAL PRIMARY KEY,
+  challenge_id INT NOT NULL REFERENCES challenges(id) ON DELETE CASCADE,
+  match_id     INT NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+  user_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  team_id      INT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  created_at   TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS solves_team_uniq ON solves(challenge_id, team_id) WHERE team_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS solves_user_uniq ON solves(challenge_id, user_id) WHERE team_id IS NULL;
+CREATE INDEX IF NOT EXISTS solves_match_idx ON solves(match_id);
+
+CREATE TABLE IF NOT EXISTS rating_changes (
+  id         BIGSERIAL PRIMARY KEY,
+  user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  match_id   INT NULL REFERENCES matches(id) ON DELETE SET NULL,
+  old_rating INT NOT NULL,
+  new_rating INT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS rating_changes_user_idx ON rating_changes(user_id);
+CREATE INDEX IF NOT EXISTS rating_changes_match_idx ON rating_changes(match_id);
+
+-- итоги команд по матчам (для рейтинга команд и отмены результата)
+CREATE TABLE IF NOT EXISTS team_results (
+  match_id     INT NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+  team_id      INT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+  place        INT NOT NULL,
+  points       INT NOT NULL,
+  won          BOOLEAN NOT NULL,
+  rating_delta INT NOT NULL,
+  created_at   TIMESTAMP NOT NULL DEFAULT now(),
+  PRIMARY KEY(match_id, team_id)
+);
+
+CREATE INDEX IF NOT EXISTS team_results_team_idx ON team_results(team_id);
+
+CREATE TABLE IF NOT EXISTS points_transactions (
+  id          BIGSERIAL PRIMARY KEY,
+  user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  delta       INT NOT NULL,
+  reason      TEXT NOT NULL,
+  match_id    INT NULL REFERENCES matches(id) ON DELETE SET NULL,
+  actor_id    INT NULL REFERENCES users(id) ON DELETE SET NULL,
+  reverted_by BIGINT NULL REFERENCES points_transactions(id) ON DELETE SET NULL,
+  created_at  TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS points_transactions_user_idx ON points_transactions(user_id);
+CREATE INDEX IF NOT EXISTS points_transactions_match_idx ON points_transactions(match_id);
+
+-- очки, начисленные до появления журнала, — одной стартовой записью
+INSERT INTO points_transactions (user_id, delta, reason)
+SELECT u.id, u.points, 'initial'
+FROM users u
+WHERE u.points <> 0
+  AND NOT EXISTS (SELECT 1 FROM points_transactions p WHERE p.user_id = u.id);
+
 -- admin123 (bcrypt)
 INSERT INTO users (username, pass_hash, role, points)
 VALUES (
@@ -1,3 +1,17 @@
+// access-токен живёт недолго: на 401 один раз пробуем обновить его
+// по refresh-cookie и повторяем запрос
+let refreshing = null;
+
+function refreshSession() {
+  if (!refreshing) {
+    refreshing = fetch("/api/auth/refresh", { method: "POST" })
+      .then(res => res.ok)
+      .catch(() => false)
+      .finally(() => { refreshing = null; });
+  }
+  return refreshing;
+}
+
 export async function api(path, method = "GET", body) {
   const opts = { method, headers: {} };
 
@@ -6,7 +20,10 @@ export async function api(path, method = "GET", body) {
     opts.body = JSON.stringify(body);
   }
 
-  const res = await fetch("/api" + path, opts);
+  let res = await fetch("/api" + path, opts);
+  if (res.status === 401 && !path.startsWith("/auth/") && await refreshSession()) {
+    res = await fetch("/api" + path, opts);
+  }
 
   // пробуем распарсить JSON всегда
   let data = null;